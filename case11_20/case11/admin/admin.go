@@ -0,0 +1,256 @@
+package admin
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"interview-cases/case11_20/case11/cache"
+	"interview-cases/case11_20/case11/interceptor"
+)
+
+// ArticleCache 管理文章缓存，service.ArticleService 实现了这个接口
+type ArticleCache interface {
+	InvalidateAuthor(ctx context.Context, author string) error
+	WarmAuthor(ctx context.Context, author string, pageSize int32, pages int) (int, error)
+}
+
+// Handler 运行时管理限流器和缓存的 HTTP 接口，和 ArticleService 部署在一起。
+// 这些接口可以修改限流配置、删除缓存，本身没有鉴权，
+// 只能监听内网地址，或者在 RegisterRouter 的时候传入鉴权的中间件
+type Handler struct {
+	mu sync.RWMutex
+	// limiters 按照 key 注册的令牌桶，key 一般是 gRPC 的方法名
+	limiters map[string]*interceptor.TokenBucket
+	// adaptive 按照 key 注册的自适应限流器
+	adaptive map[string]*interceptor.AdaptiveLimiter
+	cache    ArticleCache
+	hotKeys  *cache.HotKeyDetector
+}
+
+func NewHandler(ac ArticleCache) *Handler {
+	return &Handler{
+		limiters: make(map[string]*interceptor.TokenBucket),
+		adaptive: make(map[string]*interceptor.AdaptiveLimiter),
+		cache:    ac,
+	}
+}
+
+// RegisterLimiter 注册一个可以在运行时调整的令牌桶
+func (h *Handler) RegisterLimiter(key string, tb *interceptor.TokenBucket) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.limiters[key] = tb
+}
+
+// RegisterAdaptiveLimiter 注册一个可以在运行时调整上限范围的自适应限流器
+func (h *Handler) RegisterAdaptiveLimiter(key string, l *interceptor.AdaptiveLimiter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.adaptive[key] = l
+}
+
+// RegisterHotKeyDetector 注册之后可以通过接口查看当前的热点 key
+func (h *Handler) RegisterHotKeyDetector(d *cache.HotKeyDetector) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hotKeys = d
+}
+
+// RegisterRouter middlewares 会作用在所有的管理接口上，比如鉴权和审计日志
+func (h *Handler) RegisterRouter(server *gin.Engine, middlewares ...gin.HandlerFunc) {
+	g := server.Group("/admin", middlewares...)
+	g.GET("/limiters", h.ListLimiters)
+	// key 里面可能有 / ，比如 gRPC 的方法名，所以用 *key
+	g.GET("/limiters/*key", h.GetLimiter)
+	g.PUT("/limiters/*key", h.UpdateLimiter)
+	g.GET("/adaptive-limiters", h.ListAdaptiveLimiters)
+	g.GET("/adaptive-limiters/*key", h.GetAdaptiveLimiter)
+	g.PUT("/adaptive-limiters/*key", h.UpdateAdaptiveLimiter)
+	g.POST("/cache/invalidate", h.InvalidateCache)
+	g.POST("/cache/warm", h.WarmCache)
+	g.GET("/cache/hotkeys", h.ListHotKeys)
+}
+
+type LimiterVO struct {
+	Key string `json:"key"`
+	interceptor.TokenBucketStats
+}
+
+func (h *Handler) ListLimiters(c *gin.Context) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	res := make([]LimiterVO, 0, len(h.limiters))
+	for key, tb := range h.limiters {
+		res = append(res, LimiterVO{Key: key, TokenBucketStats: tb.Stats()})
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) GetLimiter(c *gin.Context) {
+	key, tb, ok := h.findLimiter(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, LimiterVO{Key: key, TokenBucketStats: tb.Stats()})
+}
+
+type UpdateLimiterReq struct {
+	Capacity int64 `json:"capacity"`
+	Rate     int64 `json:"rate"`
+}
+
+func (h *Handler) UpdateLimiter(c *gin.Context) {
+	key, tb, ok := h.findLimiter(c)
+	if !ok {
+		return
+	}
+	var req UpdateLimiterReq
+	if err := c.Bind(&req); err != nil {
+		return
+	}
+	if req.Capacity <= 0 || req.Rate < 0 {
+		c.String(http.StatusBadRequest, "参数错误")
+		return
+	}
+	tb.Update(req.Capacity, req.Rate)
+	slog.Info("修改限流配置", slog.String("key", key),
+		slog.Int64("capacity", req.Capacity), slog.Int64("rate", req.Rate))
+	c.JSON(http.StatusOK, LimiterVO{Key: key, TokenBucketStats: tb.Stats()})
+}
+
+func (h *Handler) findLimiter(c *gin.Context) (string, *interceptor.TokenBucket, bool) {
+	return findByKey(h, c, h.limiters)
+}
+
+type AdaptiveLimiterVO struct {
+	Key string `json:"key"`
+	interceptor.AdaptiveLimiterStats
+}
+
+func (h *Handler) ListAdaptiveLimiters(c *gin.Context) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	res := make([]AdaptiveLimiterVO, 0, len(h.adaptive))
+	for key, l := range h.adaptive {
+		res = append(res, AdaptiveLimiterVO{Key: key, AdaptiveLimiterStats: l.Stats()})
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) GetAdaptiveLimiter(c *gin.Context) {
+	key, l, ok := findByKey(h, c, h.adaptive)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, AdaptiveLimiterVO{Key: key, AdaptiveLimiterStats: l.Stats()})
+}
+
+type UpdateAdaptiveLimiterReq struct {
+	MinLimit int `json:"min_limit"`
+	MaxLimit int `json:"max_limit"`
+}
+
+func (h *Handler) UpdateAdaptiveLimiter(c *gin.Context) {
+	key, l, ok := findByKey(h, c, h.adaptive)
+	if !ok {
+		return
+	}
+	var req UpdateAdaptiveLimiterReq
+	if err := c.Bind(&req); err != nil {
+		return
+	}
+	if req.MinLimit < 1 || req.MaxLimit < req.MinLimit {
+		c.String(http.StatusBadRequest, "参数错误")
+		return
+	}
+	l.Update(req.MinLimit, req.MaxLimit)
+	slog.Info("修改自适应限流配置", slog.String("key", key),
+		slog.Int("min_limit", req.MinLimit), slog.Int("max_limit", req.MaxLimit))
+	c.JSON(http.StatusOK, AdaptiveLimiterVO{Key: key, AdaptiveLimiterStats: l.Stats()})
+}
+
+// findByKey 按照路径里面的 key 查找限流器，找不到直接返回 404
+func findByKey[T any](h *Handler, c *gin.Context, limiters map[string]T) (string, T, bool) {
+	// *key 匹配出来的值带着开头的 / ，刚好和 gRPC 的 FullMethod 格式一致。
+	// 不是以 / 开头的 key 就去掉再找一次
+	key := c.Param("key")
+	h.mu.RLock()
+	l, ok := limiters[key]
+	if !ok {
+		key = strings.TrimPrefix(key, "/")
+		l, ok = limiters[key]
+	}
+	h.mu.RUnlock()
+	if !ok {
+		c.String(http.StatusNotFound, "限流器不存在")
+		return key, l, false
+	}
+	return key, l, true
+}
+
+func (h *Handler) ListHotKeys(c *gin.Context) {
+	h.mu.RLock()
+	d := h.hotKeys
+	h.mu.RUnlock()
+	if d == nil {
+		c.JSON(http.StatusOK, []cache.HotKey{})
+		return
+	}
+	c.JSON(http.StatusOK, d.HotKeys())
+}
+
+type CacheReq struct {
+	Authors []string `json:"authors"`
+	// PageSize 和 Pages 只有预热的时候用到
+	PageSize int32 `json:"page_size"`
+	Pages    int   `json:"pages"`
+}
+
+type CacheResult struct {
+	Author string `json:"author"`
+	Pages  int    `json:"pages,omitempty"`
+	Err    string `json:"err,omitempty"`
+}
+
+func (h *Handler) InvalidateCache(c *gin.Context) {
+	h.handleCache(c, func(ctx context.Context, req CacheReq, author string) (int, error) {
+		return 0, h.cache.InvalidateAuthor(ctx, author)
+	})
+}
+
+func (h *Handler) WarmCache(c *gin.Context) {
+	h.handleCache(c, func(ctx context.Context, req CacheReq, author string) (int, error) {
+		pages := req.Pages
+		if pages <= 0 {
+			pages = 1
+		}
+		return h.cache.WarmAuthor(ctx, author, req.PageSize, pages)
+	})
+}
+
+func (h *Handler) handleCache(c *gin.Context,
+	fn func(ctx context.Context, req CacheReq, author string) (int, error)) {
+	var req CacheReq
+	if err := c.Bind(&req); err != nil {
+		return
+	}
+	if len(req.Authors) == 0 {
+		c.String(http.StatusBadRequest, "参数错误")
+		return
+	}
+	res := make([]CacheResult, 0, len(req.Authors))
+	for _, author := range req.Authors {
+		pages, err := fn(c.Request.Context(), req, author)
+		r := CacheResult{Author: author, Pages: pages}
+		if err != nil {
+			slog.Error("处理缓存失败", slog.String("author", author), slog.Any("err", err))
+			r.Err = err.Error()
+		}
+		res = append(res, r)
+	}
+	c.JSON(http.StatusOK, res)
+}
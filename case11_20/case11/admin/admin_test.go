@@ -0,0 +1,319 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"interview-cases/case11_20/case11/cache"
+	"interview-cases/case11_20/case11/interceptor"
+)
+
+type stubCache struct {
+	invalidated []string
+}
+
+func (s *stubCache) InvalidateAuthor(ctx context.Context, author string) error {
+	if author == "error" {
+		return errors.New("mock error")
+	}
+	s.invalidated = append(s.invalidated, author)
+	return nil
+}
+
+func (s *stubCache) WarmAuthor(ctx context.Context, author string, pageSize int32, pages int) (int, error) {
+	return pages, nil
+}
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ac := &stubCache{}
+	h := NewHandler(ac)
+	tb := interceptor.NewTokenBucket(5, 0)
+	h.RegisterLimiter("/proto.ArticleService/ListArticles", tb)
+	server := gin.New()
+	h.RegisterRouter(server)
+
+	testCases := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		before   func()
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:   "查看限流器",
+			method: http.MethodGet,
+			path:   "/admin/limiters/proto.ArticleService/ListArticles",
+			before: func() {
+				// 拒绝一次请求
+				tb.Consume(5)
+				tb.Consume(1)
+			},
+			wantCode: http.StatusOK,
+			wantBody: `{"key":"/proto.ArticleService/ListArticles","capacity":5,"rate":0,"tokens":0,"rejected":1}`,
+		},
+		{
+			name:     "修改限流器",
+			method:   http.MethodPut,
+			path:     "/admin/limiters/proto.ArticleService/ListArticles",
+			body:     `{"capacity":10,"rate":2}`,
+			before:   func() {},
+			wantCode: http.StatusOK,
+			wantBody: `{"key":"/proto.ArticleService/ListArticles","capacity":10,"rate":2,"tokens":0,"rejected":1}`,
+		},
+		{
+			name:     "修改限流器参数错误",
+			method:   http.MethodPut,
+			path:     "/admin/limiters/proto.ArticleService/ListArticles",
+			body:     `{"capacity":0,"rate":2}`,
+			before:   func() {},
+			wantCode: http.StatusBadRequest,
+			wantBody: "参数错误",
+		},
+		{
+			name:     "限流器不存在",
+			method:   http.MethodGet,
+			path:     "/admin/limiters/unknown",
+			before:   func() {},
+			wantCode: http.StatusNotFound,
+			wantBody: "限流器不存在",
+		},
+		{
+			name:     "删除缓存",
+			method:   http.MethodPost,
+			path:     "/admin/cache/invalidate",
+			body:     `{"authors":["tom","error"]}`,
+			before:   func() {},
+			wantCode: http.StatusOK,
+			wantBody: `[{"author":"tom"},{"author":"error","err":"mock error"}]`,
+		},
+		{
+			name:     "预热缓存",
+			method:   http.MethodPost,
+			path:     "/admin/cache/warm",
+			body:     `{"authors":["tom"],"pages":3}`,
+			before:   func() {},
+			wantCode: http.StatusOK,
+			wantBody: `[{"author":"tom","pages":3}]`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.before()
+			req, err := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+
+	// 没有注册 key 为空的限流器，列表里面只有一个
+	req, err := http.NewRequest(http.MethodGet, "/admin/limiters", nil)
+	require.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	var limiters []LimiterVO
+	err = json.Unmarshal(recorder.Body.Bytes(), &limiters)
+	require.NoError(t, err)
+	assert.Len(t, limiters, 1)
+	assert.Equal(t, []string{"tom"}, ac.invalidated)
+}
+
+func TestHandler_AdaptiveLimiter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&stubCache{})
+	h.RegisterAdaptiveLimiter("/proto.ArticleService/ListArticles", interceptor.NewAdaptiveLimiter(10, 2, 100))
+	server := gin.New()
+	h.RegisterRouter(server)
+
+	testCases := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "查看自适应限流器",
+			method:   http.MethodGet,
+			path:     "/admin/adaptive-limiters/proto.ArticleService/ListArticles",
+			wantCode: http.StatusOK,
+			wantBody: `{"key":"/proto.ArticleService/ListArticles","limit":10,"min_limit":2,"max_limit":100,"inflight":0,"min_rtt_ms":0,"rejected":0}`,
+		},
+		{
+			name:     "调低上限",
+			method:   http.MethodPut,
+			path:     "/admin/adaptive-limiters/proto.ArticleService/ListArticles",
+			body:     `{"min_limit":1,"max_limit":5}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"key":"/proto.ArticleService/ListArticles","limit":5,"min_limit":1,"max_limit":5,"inflight":0,"min_rtt_ms":0,"rejected":0}`,
+		},
+		{
+			name:     "下限小于1",
+			method:   http.MethodPut,
+			path:     "/admin/adaptive-limiters/proto.ArticleService/ListArticles",
+			body:     `{"min_limit":0,"max_limit":5}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: "参数错误",
+		},
+		{
+			name:     "上限小于下限",
+			method:   http.MethodPut,
+			path:     "/admin/adaptive-limiters/proto.ArticleService/ListArticles",
+			body:     `{"min_limit":10,"max_limit":5}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: "参数错误",
+		},
+		{
+			name:     "限流器不存在",
+			method:   http.MethodGet,
+			path:     "/admin/adaptive-limiters/unknown",
+			wantCode: http.StatusNotFound,
+			wantBody: "限流器不存在",
+		},
+		{
+			name:     "列表",
+			method:   http.MethodGet,
+			path:     "/admin/adaptive-limiters",
+			wantCode: http.StatusOK,
+			wantBody: `[{"key":"/proto.ArticleService/ListArticles","limit":5,"min_limit":1,"max_limit":5,"inflight":0,"min_rtt_ms":0,"rejected":0}]`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantCode, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+}
+
+// TestHandler_AdaptiveLimiterRejected 能看到自适应限流器拒绝了多少请求
+func TestHandler_AdaptiveLimiterRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&stubCache{})
+	l := interceptor.NewAdaptiveLimiter(1, 1, 1)
+	h.RegisterAdaptiveLimiter("/proto.ArticleService/ListArticles", l)
+	server := gin.New()
+	h.RegisterRouter(server)
+
+	_, ok := l.Allow()
+	require.True(t, ok)
+	for i := 0; i < 3; i++ {
+		_, ok = l.Allow()
+		require.False(t, ok)
+	}
+	req, err := http.NewRequest(http.MethodGet, "/admin/adaptive-limiters/proto.ArticleService/ListArticles", nil)
+	require.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, `{"key":"/proto.ArticleService/ListArticles","limit":1,"min_limit":1,"max_limit":1,"inflight":1,"min_rtt_ms":0,"rejected":3}`,
+		recorder.Body.String())
+}
+
+// TestHandler_Middleware 鉴权之类的中间件对所有的管理接口生效
+func TestHandler_Middleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&stubCache{})
+	server := gin.New()
+	h.RegisterRouter(server, func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "Bearer admin" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+		}
+	})
+
+	for _, auth := range []string{"", "Bearer admin"} {
+		req, err := http.NewRequest(http.MethodPost, "/admin/cache/invalidate", strings.NewReader(`{"authors":["tom"]}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", auth)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		if auth == "" {
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+			assert.Empty(t, h.cache.(*stubCache).invalidated)
+		} else {
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.Equal(t, []string{"tom"}, h.cache.(*stubCache).invalidated)
+		}
+	}
+}
+
+func TestHandler_ListHotKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&stubCache{})
+	server := gin.New()
+	h.RegisterRouter(server)
+
+	list := func() string {
+		req, err := http.NewRequest(http.MethodGet, "/admin/cache/hotkeys", nil)
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		return recorder.Body.String()
+	}
+	// 没有开启热点探测
+	assert.Equal(t, "[]", list())
+
+	d := cache.NewHotKeyDetector(time.Minute, 2, 10)
+	h.RegisterHotKeyDetector(d)
+	d.Record("article:tom:20:")
+	d.Record("article:tom:20:")
+	d.Record("article:jerry:20:")
+	assert.Equal(t, `[{"key":"article:tom:20:","count":2}]`, list())
+}
+
+func TestWatchLimiterHealth(t *testing.T) {
+	hs := health.NewServer()
+	tb := interceptor.NewTokenBucket(1, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go WatchLimiterHealth(ctx, hs, "proto.ArticleService", tb, time.Millisecond*10)
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := hs.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return healthpb.HealthCheckResponse_UNKNOWN
+		}
+		return resp.Status
+	}
+	full := FullServiceName("proto.ArticleService")
+	assert.Eventually(t, func() bool {
+		return check(full) == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, time.Millisecond*10)
+
+	// 令牌用完，处于降级状态，但是实例还在提供服务
+	tb.Consume(1)
+	assert.Eventually(t, func() bool {
+		return check(full) == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check("proto.ArticleService"))
+
+	tb.Add(1)
+	assert.Eventually(t, func() bool {
+		return check(full) == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, time.Millisecond*10)
+}
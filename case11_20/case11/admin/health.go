@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Saturable 能够判断是否已经打满的限流器，TokenBucket 和 AdaptiveLimiter 都实现了
+type Saturable interface {
+	Saturated() bool
+}
+
+// FullServiceName 表示实例是否完整提供服务的健康检查名字。
+// 限流器打满之后它是 NOT_SERVING，这时候实例处于降级状态，只能返回缓存里面的数据
+func FullServiceName(service string) string {
+	return service + "/full"
+}
+
+// WatchLimiterHealth 定时检查限流器，把降级状态报告在 FullServiceName(service) 上面。
+// 降级的实例还在提供服务，所以 service 本身一直是 SERVING，负载均衡不会把它摘掉。
+// 整个进程的健康状态（service 为空字符串）不受影响。会一直阻塞到 ctx 被取消
+func WatchLimiterHealth(ctx context.Context, hs *health.Server, service string,
+	limiter Saturable, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	hs.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		if limiter.Saturated() {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		hs.SetServingStatus(FullServiceName(service), status)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"hash/maphash"
+	"sort"
+	"sync"
+	"time"
+)
+
+// countMinSketch 用固定大小的内存估算每个 key 的访问次数，只会高估不会低估
+type countMinSketch struct {
+	seeds  []maphash.Seed
+	counts [][]uint32
+	width  uint64
+}
+
+func newCountMinSketch(seeds []maphash.Seed, width int) *countMinSketch {
+	counts := make([][]uint32, len(seeds))
+	for i := range counts {
+		counts[i] = make([]uint32, width)
+	}
+	return &countMinSketch{seeds: seeds, counts: counts, width: uint64(width)}
+}
+
+func (s *countMinSketch) add(key string) {
+	for i, seed := range s.seeds {
+		s.counts[i][maphash.String(seed, key)%s.width]++
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint32 {
+	var res uint32
+	for i, seed := range s.seeds {
+		cnt := s.counts[i][maphash.String(seed, key)%s.width]
+		if i == 0 || cnt < res {
+			res = cnt
+		}
+	}
+	return res
+}
+
+func (s *countMinSketch) reset() {
+	for _, row := range s.counts {
+		clear(row)
+	}
+}
+
+// HotKey 热点 key 以及窗口内估算的访问次数
+type HotKey struct {
+	Key   string `json:"key"`
+	Count uint32 `json:"count"`
+}
+
+// HotKeyDetector 在滑动窗口内统计 key 的访问次数，找出访问次数超过阈值的 top K 个 key。
+// 窗口被切分成多个桶，每个桶是一个 count-min sketch，过了一个桶的时间就清空最老的桶
+type HotKeyDetector struct {
+	mu         sync.Mutex
+	buckets    []*countMinSketch
+	cur        int
+	bucketDur  time.Duration
+	lastRotate time.Time
+	threshold  uint32
+	topK       int
+	// hot 当前的热点 key 以及估算的访问次数
+	hot map[string]uint32
+	now func() time.Time
+}
+
+// NewHotKeyDetector window 是统计的时间窗口，threshold 是窗口内访问多少次算热点，topK 是最多保留多少个热点
+func NewHotKeyDetector(window time.Duration, threshold uint32, topK int) *HotKeyDetector {
+	const (
+		bucketCnt = 6
+		depth     = 4
+		width     = 2048
+	)
+	seeds := make([]maphash.Seed, depth)
+	for i := range seeds {
+		seeds[i] = maphash.MakeSeed()
+	}
+	buckets := make([]*countMinSketch, bucketCnt)
+	for i := range buckets {
+		buckets[i] = newCountMinSketch(seeds, width)
+	}
+	return &HotKeyDetector{
+		buckets:    buckets,
+		bucketDur:  window / bucketCnt,
+		lastRotate: time.Now(),
+		threshold:  threshold,
+		topK:       topK,
+		hot:        make(map[string]uint32, topK),
+		now:        time.Now,
+	}
+}
+
+// Record 记录一次访问，返回这个 key 是不是热点
+func (d *HotKeyDetector) Record(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rotate()
+	d.buckets[d.cur].add(key)
+	cnt := d.estimate(key)
+	if cnt < d.threshold {
+		return false
+	}
+	if _, ok := d.hot[key]; !ok && len(d.hot) >= d.topK {
+		// 满了，把访问次数最少的挤出去
+		minKey, minCnt := d.coldest()
+		if minCnt >= cnt {
+			return false
+		}
+		delete(d.hot, minKey)
+	}
+	d.hot[key] = cnt
+	return true
+}
+
+// IsHot 判断 key 是不是热点，不会记录访问
+func (d *HotKeyDetector) IsHot(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rotate()
+	_, ok := d.hot[key]
+	return ok
+}
+
+// HotKeys 当前所有的热点 key，按照访问次数从多到少排序
+func (d *HotKeyDetector) HotKeys() []HotKey {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rotate()
+	res := make([]HotKey, 0, len(d.hot))
+	for key, cnt := range d.hot {
+		res = append(res, HotKey{Key: key, Count: cnt})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count == res[j].Count {
+			return res[i].Key < res[j].Key
+		}
+		return res[i].Count > res[j].Count
+	})
+	return res
+}
+
+// rotate 过期的桶清空，并且重新计算热点，调用方需要持有锁
+func (d *HotKeyDetector) rotate() {
+	now := d.now()
+	rotated := false
+	for i := 0; i < len(d.buckets) && now.Sub(d.lastRotate) >= d.bucketDur; i++ {
+		d.cur = (d.cur + 1) % len(d.buckets)
+		d.buckets[d.cur].reset()
+		d.lastRotate = d.lastRotate.Add(d.bucketDur)
+		rotated = true
+	}
+	if !rotated {
+		return
+	}
+	// 很久没有访问，整个窗口都过期了
+	if now.Sub(d.lastRotate) >= d.bucketDur {
+		d.lastRotate = now
+	}
+	for key := range d.hot {
+		cnt := d.estimate(key)
+		if cnt < d.threshold {
+			delete(d.hot, key)
+		} else {
+			d.hot[key] = cnt
+		}
+	}
+}
+
+func (d *HotKeyDetector) estimate(key string) uint32 {
+	var cnt uint32
+	for _, b := range d.buckets {
+		cnt += b.estimate(key)
+	}
+	return cnt
+}
+
+func (d *HotKeyDetector) coldest() (string, uint32) {
+	var (
+		minKey string
+		minCnt uint32
+		first  = true
+	)
+	for key, cnt := range d.hot {
+		if first || cnt < minCnt {
+			minKey, minCnt, first = key, cnt, false
+		}
+	}
+	return minKey, minCnt
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotKeyDetector(t *testing.T) {
+	d := NewHotKeyDetector(time.Minute, 10, 2)
+	now := time.Now()
+	d.now = func() time.Time {
+		return now
+	}
+	d.lastRotate = now
+
+	// 普通 key 访问次数没有达到阈值
+	for i := 0; i < 100; i++ {
+		assert.False(t, d.Record(fmt.Sprintf("article:user_%d", i)))
+	}
+	for i := 0; i < 9; i++ {
+		assert.False(t, d.Record("article:hot1"))
+	}
+	assert.True(t, d.Record("article:hot1"))
+	for i := 0; i < 20; i++ {
+		d.Record("article:hot2")
+	}
+	assert.True(t, d.IsHot("article:hot2"))
+
+	// 最多只保留两个热点，访问次数更多的会把最少的挤出去
+	for i := 0; i < 15; i++ {
+		d.Record("article:hot3")
+	}
+	assert.Equal(t, []HotKey{
+		{Key: "article:hot2", Count: 20},
+		{Key: "article:hot3", Count: 15},
+	}, d.HotKeys())
+
+	// 窗口滑过去之后，热点就没了
+	now = now.Add(time.Minute)
+	assert.False(t, d.IsHot("article:hot2"))
+	assert.Empty(t, d.HotKeys())
+}
+
+func TestHotKeyDetector_SlidingWindow(t *testing.T) {
+	d := NewHotKeyDetector(time.Minute, 10, 10)
+	now := time.Now()
+	d.now = func() time.Time {
+		return now
+	}
+	d.lastRotate = now
+
+	// 前半分钟访问 6 次，后半分钟再访问 6 次，窗口内一共 12 次
+	for i := 0; i < 6; i++ {
+		d.Record("article:hot")
+	}
+	now = now.Add(time.Second * 30)
+	for i := 0; i < 5; i++ {
+		d.Record("article:hot")
+	}
+	assert.True(t, d.Record("article:hot"))
+	// 再过 40 秒，前面 6 次滑出了窗口
+	now = now.Add(time.Second * 40)
+	assert.False(t, d.IsHot("article:hot"))
+}
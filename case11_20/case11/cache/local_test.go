@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalCache_LRU(t *testing.T) {
+	c := NewLocalCache(2, time.Minute)
+	c.Set("a", []byte("a"))
+	c.Set("b", []byte("b"))
+	// 访问一下 a，b 就变成了最久没有访问的
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+	c.Set("c", []byte("c"))
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	val, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), val)
+	val, ok = c.Get("c")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("c"), val)
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestLocalCache_Expire(t *testing.T) {
+	c := NewLocalCache(10, time.Millisecond*10)
+	c.Set("a", []byte("a"))
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+	time.Sleep(time.Millisecond * 20)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestLocalCache_DeletePrefix(t *testing.T) {
+	c := NewLocalCache(10, time.Minute)
+	c.Set("article:tom:20:", []byte("1"))
+	c.Set("article:tom:20:abc", []byte("2"))
+	c.Set("article:tomas:20:", []byte("3"))
+	c.Set("article:jerry:20:", []byte("4"))
+
+	assert.Equal(t, 2, c.DeletePrefix("article:tom:"))
+	_, ok := c.Get("article:tomas:20:")
+	assert.True(t, ok)
+	_, ok = c.Get("article:jerry:20:")
+	assert.True(t, ok)
+	assert.Equal(t, 2, c.Len())
+}
@@ -3,6 +3,7 @@ package case11
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	interceptor2 "interview-cases/case11_20/case11/interceptor"
@@ -82,7 +83,7 @@ func TestCase11_(t *testing.T) {
 				}
 
 				// 先清空redis数据
-				key := "article:redis"
+				key := service.ArticlePageKey("mysql", 0, "")
 				err := client.Del(context.Background(), key).Err()
 				assert.NoError(t, err)
 
@@ -101,6 +102,8 @@ func TestCase11_(t *testing.T) {
 				// 清空mysql
 				err := db.Exec("TRUNCATE TABLE `articles`").Error
 				assert.NoError(t, err)
+				err = client.Del(context.Background(), service.ArticlePageKey("mysql", 0, "")).Err()
+				assert.NoError(t, err)
 			},
 			wantRes: &pb2.ListArticlesResponse{
 				Articles: []*pb2.Article{
@@ -128,23 +131,26 @@ func TestCase11_(t *testing.T) {
 				}
 
 				// 先给redis预加载数据
-				key := "article:redis"
-				list := []*pb2.Article{
+				key := service.ArticlePageKey("redis", 0, "")
+				list := &pb2.ListArticlesResponse{Articles: []*pb2.Article{
 					&pb2.Article{
 						Id:      1,
 						Title:   "redis",
 						Author:  "redis",
 						Content: "没限流但是只查询redis",
 					},
-				}
-				val, _ := json.Marshal(&list)
+				}}
+				val, _ := json.Marshal(&service.CachedArticleList{
+					ExpireAt: time.Now().Add(time.Minute * 10).UnixMilli(),
+					Data:     list,
+				})
 				client.Set(context.Background(), key, val, time.Minute*10)
 
 				assert.NoError(t, err)
 			},
 			after: func() {
 				// 清空redis
-				key := "article:redis"
+				key := service.ArticlePageKey("redis", 0, "")
 				err := client.Del(context.Background(), key).Err()
 				assert.NoError(t, err)
 			},
@@ -174,23 +180,26 @@ func TestCase11_(t *testing.T) {
 				}
 
 				// 先给redis预加载数据
-				key := "article:redis"
-				list := []*pb2.Article{
+				key := service.ArticlePageKey("redis", 0, "")
+				list := &pb2.ListArticlesResponse{Articles: []*pb2.Article{
 					&pb2.Article{
 						Id:      1,
 						Title:   "redis",
 						Author:  "redis",
 						Content: "限流但是只查询redis",
 					},
-				}
-				val, _ := json.Marshal(&list)
+				}}
+				val, _ := json.Marshal(&service.CachedArticleList{
+					ExpireAt: time.Now().Add(time.Minute * 10).UnixMilli(),
+					Data:     list,
+				})
 				client.Set(context.Background(), key, val, time.Minute*10)
 
 				assert.NoError(t, err)
 			},
 			after: func() {
 				// 清空redis
-				key := "article:redis"
+				key := service.ArticlePageKey("redis", 0, "")
 				err := client.Del(context.Background(), key).Err()
 				assert.NoError(t, err)
 			},
@@ -221,12 +230,12 @@ func TestCase11_(t *testing.T) {
 			},
 			after: func() {
 				// 清空redis
-				key := "article:redis"
+				key := service.ArticlePageKey("redis", 0, "")
 				err := client.Del(context.Background(), key).Err()
 				assert.NoError(t, err)
 			},
 			wantRes: nil,
-			wantErr: status.Errorf(codes.Unknown, "数据不存在redis"),
+			wantErr: status.Errorf(codes.ResourceExhausted, "数据不存在redis"),
 		},
 	}
 
@@ -247,6 +256,56 @@ func TestCase11_(t *testing.T) {
 		})
 	}
 
+	t.Run("没有限流,按照游标分页查询全部文章", func(t *testing.T) {
+		tokens := tokenBucket.Tokens()
+		if tokens <= 0 {
+			tokenBucket.Add(100)
+		}
+		for i := int32(1); i <= 5; i++ {
+			err := db.Create(&service.Article{
+				ID:      i,
+				Title:   fmt.Sprintf("page_%d", i),
+				Author:  "page",
+				Content: "分页查询",
+			}).Error
+			require.NoError(t, err)
+		}
+		defer func() {
+			err := db.Exec("TRUNCATE TABLE `articles`").Error
+			assert.NoError(t, err)
+		}()
+
+		var (
+			ids       []int32
+			pageToken string
+			pages     int
+		)
+		for {
+			key := service.ArticlePageKey("page", 2, pageToken)
+			// 每一页都有自己的缓存
+			defer client.Del(context.Background(), key)
+			resp, err := grpcClient.ListArticles(context.Background(), &pb2.ListArticlesRequest{
+				Author:    "page",
+				PageSize:  2,
+				PageToken: pageToken,
+			})
+			require.NoError(t, err)
+			pages++
+			for _, a := range resp.Articles {
+				ids = append(ids, a.Id)
+			}
+			exists, err := client.Exists(context.Background(), key).Result()
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), exists)
+			if resp.NextPageToken == "" {
+				break
+			}
+			pageToken = resp.NextPageToken
+		}
+		assert.Equal(t, []int32{1, 2, 3, 4, 5}, ids)
+		assert.Equal(t, 3, pages)
+	})
+
 	// 关闭grpc服务
 
 }
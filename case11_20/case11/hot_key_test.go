@@ -0,0 +1,83 @@
+package case11
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/case11_20/case11/cache"
+	pb2 "interview-cases/case11_20/case11/pb"
+	"interview-cases/case11_20/case11/service"
+	"interview-cases/test"
+)
+
+func TestCase11_HotKey(t *testing.T) {
+	db := test.InitDB()
+	client := test.InitRedis()
+	local := cache.NewLocalCache(100, time.Minute)
+	detector := cache.NewHotKeyDetector(time.Minute, 3, 10)
+	svc := service.NewArticleService(client, db,
+		service.WithLocalCache(local),
+		service.WithHotKeyDetector(detector, 4))
+
+	ctx := context.Background()
+	hotKey := service.ArticlePageKey("hot", 0, "")
+	coldKey := service.ArticlePageKey("cold", 0, "")
+	for _, key := range []string{hotKey, coldKey} {
+		val, err := json.Marshal(&service.CachedArticleList{
+			ExpireAt: time.Now().Add(time.Minute).UnixMilli(),
+			Data: &pb2.ListArticlesResponse{Articles: []*pb2.Article{
+				{Id: 1, Title: key, Author: key, Content: "热点探测"},
+			}},
+		})
+		require.NoError(t, err)
+		err = client.Set(ctx, key, val, time.Minute).Err()
+		require.NoError(t, err)
+	}
+	defer func() {
+		err := svc.InvalidateAuthor(ctx, "hot")
+		assert.NoError(t, err)
+		err = svc.InvalidateAuthor(ctx, "cold")
+		assert.NoError(t, err)
+	}()
+
+	_, err := svc.ListArticles(ctx, &pb2.ListArticlesRequest{Author: "cold"})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = svc.ListArticles(ctx, &pb2.ListArticlesRequest{Author: "hot"})
+		require.NoError(t, err)
+	}
+
+	// 只有热点 key 进了本地缓存
+	_, ok := local.Get(hotKey)
+	assert.True(t, ok)
+	_, ok = local.Get(coldKey)
+	assert.False(t, ok)
+	assert.Equal(t, hotKey, detector.HotKeys()[0].Key)
+
+	// 热点 key 写缓存的时候，所有副本都会写
+	_, err = svc.WarmAuthor(ctx, "hot", 0, 1)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		exists, err := client.Exists(ctx, fmt.Sprintf("%s#%d", hotKey, i)).Result()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), exists)
+		ttl, err := client.TTL(ctx, fmt.Sprintf("%s#%d", hotKey, i)).Result()
+		require.NoError(t, err)
+		assert.LessOrEqual(t, ttl, service.DefaultLogicalTTL)
+	}
+
+	// 不再是热点之后，写缓存会删掉副本
+	svc.HotKeys = cache.NewHotKeyDetector(time.Minute, 3, 10)
+	_, err = svc.WarmAuthor(ctx, "hot", 0, 1)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		exists, err := client.Exists(ctx, fmt.Sprintf("%s#%d", hotKey, i)).Result()
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), exists)
+	}
+}
@@ -0,0 +1,158 @@
+package interceptor
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// defaultRTTWindow 统计最小响应时间的窗口
+const defaultRTTWindow = 10 * time.Second
+
+// AdaptiveLimiter 基于梯度的自适应并发限流器。
+// 它限制的是同时处理中的请求数量，并且根据响应时间自动调整这个上限：
+// 响应时间接近历史最小值的时候说明 MySQL 还很轻松，慢慢调高上限；
+// 响应时间变长说明请求开始排队了，按照 最小响应时间/当前响应时间 的比例调低上限
+type AdaptiveLimiter struct {
+	mu       sync.Mutex
+	limit    float64
+	minLimit float64
+	maxLimit float64
+	inflight int
+	rejected int64 // 累计拒绝的请求数
+	// minRTT 最近观察到的最小响应时间，认为是没有排队时候的响应时间。
+	// 它是上一个窗口和当前窗口里面的最小值，数据量变大之类的原因让响应时间整体变长之后，
+	// 过一个窗口就会跟着变大，不然上限会一直被压在很低的位置
+	minRTT       time.Duration
+	windowMinRTT time.Duration
+	windowStart  time.Time
+	rttWindow    time.Duration
+	// smoothing 每次调整的平滑系数，越小调整越平缓
+	smoothing float64
+	now       func() time.Time
+}
+
+// NewAdaptiveLimiter 创建自适应限流器，initLimit 是初始的并发上限。
+// minLimit 最小是 1，上限降到 0 之后所有请求都被拒绝，也就没有响应时间可以用来恢复了
+func NewAdaptiveLimiter(initLimit, minLimit, maxLimit int) *AdaptiveLimiter {
+	minLimit = max(minLimit, 1)
+	maxLimit = max(maxLimit, minLimit)
+	initLimit = min(max(initLimit, minLimit), maxLimit)
+	return &AdaptiveLimiter{
+		limit:     float64(initLimit),
+		minLimit:  float64(minLimit),
+		maxLimit:  float64(maxLimit),
+		smoothing: 0.2,
+		rttWindow: defaultRTTWindow,
+		now:       time.Now,
+	}
+}
+
+func (l *AdaptiveLimiter) Allow() (func(), bool) {
+	l.mu.Lock()
+	if l.inflight >= int(l.limit) {
+		l.rejected++
+		l.mu.Unlock()
+		return nil, false
+	}
+	l.inflight++
+	inflight := l.inflight
+	l.mu.Unlock()
+
+	start := l.now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.onSample(l.now().Sub(start), inflight)
+		})
+	}, true
+}
+
+// onSample 请求结束，根据响应时间调整上限
+func (l *AdaptiveLimiter) onSample(rtt time.Duration, inflight int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	if rtt <= 0 {
+		return
+	}
+	if now := l.now(); now.Sub(l.windowStart) >= l.rttWindow {
+		l.minRTT = l.windowMinRTT
+		l.windowMinRTT, l.windowStart = 0, now
+	}
+	if l.windowMinRTT == 0 || rtt < l.windowMinRTT {
+		l.windowMinRTT = rtt
+	}
+	if l.minRTT == 0 || rtt < l.minRTT {
+		l.minRTT = rtt
+	}
+
+	gradient := float64(l.minRTT) / float64(rtt)
+	// 避免一次抖动就把上限砍得太狠
+	gradient = math.Max(0.5, math.Min(1, gradient))
+	// 允许少量排队，这样上限才有机会往上涨
+	queueSize := math.Sqrt(l.limit)
+	newLimit := l.limit*gradient + queueSize
+	// 并发量远没有达到上限的时候，响应时间说明不了问题，不要继续调高
+	if newLimit > l.limit && float64(inflight) < l.limit/2 {
+		return
+	}
+	newLimit = l.limit*(1-l.smoothing) + newLimit*l.smoothing
+	l.limit = math.Max(l.minLimit, math.Min(l.maxLimit, newLimit))
+}
+
+// AdaptiveLimiterStats 自适应限流器的当前状态
+type AdaptiveLimiterStats struct {
+	Limit    int   `json:"limit"`
+	MinLimit int   `json:"min_limit"`
+	MaxLimit int   `json:"max_limit"`
+	Inflight int   `json:"inflight"`
+	MinRTTMs int64 `json:"min_rtt_ms"`
+	Rejected int64 `json:"rejected"`
+}
+
+// Update 运行时修改并发上限的范围，当前上限会被调整到新的范围里面
+func (l *AdaptiveLimiter) Update(minLimit, maxLimit int) {
+	minLimit = max(minLimit, 1)
+	maxLimit = max(maxLimit, minLimit)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.minLimit = float64(minLimit)
+	l.maxLimit = float64(maxLimit)
+	l.limit = math.Max(l.minLimit, math.Min(l.maxLimit, l.limit))
+}
+
+// Stats 返回自适应限流器的当前状态
+func (l *AdaptiveLimiter) Stats() AdaptiveLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return AdaptiveLimiterStats{
+		Limit:    int(l.limit),
+		MinLimit: int(l.minLimit),
+		MaxLimit: int(l.maxLimit),
+		Inflight: l.inflight,
+		MinRTTMs: l.minRTT.Milliseconds(),
+		Rejected: l.rejected,
+	}
+}
+
+// Limit 当前的并发上限
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// Saturated 并发已经达到上限，新的请求都会被限流
+func (l *AdaptiveLimiter) Saturated() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight >= int(l.limit)
+}
+
+// Inflight 正在处理中的请求数量
+func (l *AdaptiveLimiter) Inflight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight
+}
@@ -0,0 +1,143 @@
+package interceptor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAdaptiveLimiter_Simulation 模拟 MySQL 从正常到变慢再到恢复，限流器的并发上限要跟着变化
+func TestAdaptiveLimiter_Simulation(t *testing.T) {
+	l := NewAdaptiveLimiter(10, 2, 200)
+	simulate := newSimulation(l)
+
+	simulate(20, time.Millisecond*10)
+	healthy := l.Limit()
+	assert.Greater(t, healthy, 10)
+	assert.Equal(t, 0, l.Inflight())
+
+	// MySQL 开始变慢，上限要降下来
+	simulate(20, time.Millisecond*50)
+	slow := l.Limit()
+	assert.Less(t, slow, healthy/2)
+	assert.GreaterOrEqual(t, slow, 2)
+
+	// 恢复之后上限又会涨回去
+	simulate(20, time.Millisecond*10)
+	assert.Greater(t, l.Limit(), slow)
+}
+
+func TestAdaptiveLimiter_Reject(t *testing.T) {
+	l := NewAdaptiveLimiter(2, 1, 10)
+	done1, ok := l.Allow()
+	assert.True(t, ok)
+	_, ok = l.Allow()
+	assert.True(t, ok)
+	// 超过了并发上限
+	_, ok = l.Allow()
+	assert.False(t, ok)
+	assert.Equal(t, int64(1), l.Stats().Rejected)
+
+	done1()
+	// 重复调用 done 不会影响计数
+	done1()
+	assert.Equal(t, 1, l.Inflight())
+	_, ok = l.Allow()
+	assert.True(t, ok)
+}
+
+// TestAdaptiveLimiter_MinLimit 上限在最低的时候还能放行请求，并且能够恢复
+func TestAdaptiveLimiter_MinLimit(t *testing.T) {
+	// minLimit 是 0 的时候按照 1 处理，初始上限也不能低于它
+	l := NewAdaptiveLimiter(0, 0, 100)
+	assert.Equal(t, 1, l.Limit())
+	simulate := newSimulation(l)
+	simulate(1, time.Millisecond*10)
+	// 响应时间一直变长，上限不会低于 1
+	for rtt := time.Millisecond * 20; rtt < time.Second*10; rtt *= 2 {
+		simulate(5, rtt)
+		assert.GreaterOrEqual(t, l.Limit(), 1)
+	}
+
+	simulate(50, time.Millisecond)
+	assert.Greater(t, l.Limit(), 1)
+}
+
+// TestAdaptiveLimiter_MinRTTWindow 响应时间整体变长之后，最小响应时间会跟着变大，上限能够恢复
+func TestAdaptiveLimiter_MinRTTWindow(t *testing.T) {
+	l := NewAdaptiveLimiter(10, 2, 200)
+	l.rttWindow = time.Second
+	simulate := newSimulation(l)
+
+	simulate(20, time.Millisecond*10)
+	healthy := l.Limit()
+	simulate(10, time.Millisecond*50)
+	slow := l.Limit()
+	assert.Less(t, slow, healthy)
+	assert.Equal(t, int64(10), l.Stats().MinRTTMs)
+
+	// 超过两个窗口之后，10ms 的样本已经过期了
+	simulate(60, time.Millisecond*50)
+	assert.Equal(t, int64(50), l.Stats().MinRTTMs)
+	assert.Greater(t, l.Limit(), slow)
+}
+
+// newSimulation 返回的函数每一轮把并发打满，然后让所有请求以 rtt 的耗时结束
+func newSimulation(l *AdaptiveLimiter) func(rounds int, rtt time.Duration) {
+	var now time.Time
+	l.now = func() time.Time {
+		return now
+	}
+	return func(rounds int, rtt time.Duration) {
+		for i := 0; i < rounds; i++ {
+			var dones []func()
+			for {
+				done, ok := l.Allow()
+				if !ok {
+					break
+				}
+				dones = append(dones, done)
+			}
+			now = now.Add(rtt)
+			for _, done := range dones {
+				done()
+			}
+		}
+	}
+}
+
+func TestAdaptiveLimiter_Update(t *testing.T) {
+	testCases := []struct {
+		name     string
+		minLimit int
+		maxLimit int
+		want     AdaptiveLimiterStats
+	}{
+		{
+			name:     "调低上限",
+			minLimit: 1,
+			maxLimit: 5,
+			want:     AdaptiveLimiterStats{Limit: 5, MinLimit: 1, MaxLimit: 5},
+		},
+		{
+			name:     "调高下限",
+			minLimit: 20,
+			maxLimit: 50,
+			want:     AdaptiveLimiterStats{Limit: 20, MinLimit: 20, MaxLimit: 50},
+		},
+		{
+			name:     "下限是0",
+			minLimit: 0,
+			maxLimit: 0,
+			want:     AdaptiveLimiterStats{Limit: 1, MinLimit: 1, MaxLimit: 1},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := NewAdaptiveLimiter(10, 2, 100)
+			l.Update(tc.minLimit, tc.maxLimit)
+			assert.Equal(t, tc.want, l.Stats())
+		})
+	}
+}
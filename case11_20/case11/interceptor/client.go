@@ -0,0 +1,167 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RetryOptions 客户端重试的配置
+type RetryOptions struct {
+	// MaxAttempts 最多调用几次，包含第一次调用
+	MaxAttempts int
+	// InitialBackoff 第一次重试的退避时间，之后每次翻倍，并且加上随机抖动
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// PerCallTimeout 每一次调用的超时时间，为 0 的时候只受调用方 ctx 控制
+	PerCallTimeout time.Duration
+	// Budget 重试预算，为 nil 的时候不限制
+	Budget *RetryBudget
+}
+
+// UnaryClientRetryInterceptor 识别服务端的限流响应，按照 retry-after 和退避时间重试
+func UnaryClientRetryInterceptor(opts RetryOptions) grpc.UnaryClientInterceptor {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+	return func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		var err error
+		for attempt := 0; attempt < opts.MaxAttempts; attempt++ {
+			var header metadata.MD
+			// 限制容量，append 的时候不会写到调用方的底层数组里面
+			err = invokeOnce(ctx, opts.PerCallTimeout, method, req, reply, cc, invoker,
+				append(callOpts[:len(callOpts):len(callOpts)], grpc.Header(&header))...)
+			if err == nil {
+				opts.Budget.onSuccess()
+				return nil
+			}
+			if !shouldRetry(ctx, err) || attempt == opts.MaxAttempts-1 {
+				return err
+			}
+			if !opts.Budget.allowRetry() {
+				return err
+			}
+			// 服务端告诉了多久之后重试，就至少等这么久
+			wait := backoff(opts, attempt)
+			if d := retryAfter(header); d > wait {
+				wait = d
+			}
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+				// 等不到下一次重试了
+				return err
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			}
+		}
+		return err
+	}
+}
+
+func invokeOnce(ctx context.Context, timeout time.Duration, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return invoker(ctx, method, req, reply, cc, callOpts...)
+}
+
+// shouldRetry 服务端限流、资源耗尽、暂时不可用，或者单次调用超时而整体还没超时，都可以重试。
+// 限流的 header 只是说明服务端处于降级状态，其它错误码即便带着它也不重试
+func shouldRetry(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+func retryAfter(header metadata.MD) time.Duration {
+	vals := header.Get(HeaderRetryAfter)
+	if len(vals) == 0 {
+		return 0
+	}
+	ms, err := strconv.ParseInt(vals[0], 10, 64)
+	if err != nil || ms < 0 {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// backoff 指数退避加上全抖动，避免所有客户端同时重试
+func backoff(opts RetryOptions, attempt int) time.Duration {
+	d := opts.InitialBackoff << attempt
+	if d <= 0 || (opts.MaxBackoff > 0 && d > opts.MaxBackoff) {
+		d = opts.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// RetryBudget 重试预算，和 gRPC 的重试节流一个思路：
+// 失败消耗一个令牌，成功归还 ratio 个令牌，令牌少于一半的时候不再重试，
+// 避免服务端过载的时候客户端的重试把它彻底压垮
+type RetryBudget struct {
+	mu        sync.Mutex
+	tokens    float64
+	maxTokens float64
+	ratio     float64
+}
+
+func NewRetryBudget(maxTokens, ratio float64) *RetryBudget {
+	if maxTokens <= 0 {
+		panic(errors.New("maxTokens 必须大于 0"))
+	}
+	return &RetryBudget{
+		tokens:    maxTokens,
+		maxTokens: maxTokens,
+		ratio:     ratio,
+	}
+}
+
+func (b *RetryBudget) allowRetry() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Max(b.tokens-1, 0)
+	return b.tokens > b.maxTokens/2
+}
+
+func (b *RetryBudget) onSuccess() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.tokens+b.ratio, b.maxTokens)
+}
+
+// Tokens 当前剩余的令牌
+func (b *RetryBudget) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens
+}
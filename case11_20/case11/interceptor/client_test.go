@@ -0,0 +1,194 @@
+package interceptor
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"interview-cases/case11_20/case11/pb"
+)
+
+// rejectLimiter 前 rejectCnt 次请求都限流
+type rejectLimiter struct {
+	rejectCnt  int64
+	cnt        int64
+	retryAfter time.Duration
+}
+
+func (l *rejectLimiter) Allow() (func(), bool) {
+	return func() {}, atomic.AddInt64(&l.cnt, 1) > l.rejectCnt
+}
+
+func (l *rejectLimiter) RetryAfter() time.Duration {
+	return l.retryAfter
+}
+
+// stubArticleService 和 ArticleService 一样，被限流之后返回错误
+type stubArticleService struct {
+	pb.UnimplementedArticleServiceServer
+	calls int64
+	// slowCalls 前几次调用都很慢
+	slowCalls int64
+}
+
+func (s *stubArticleService) ListArticles(ctx context.Context, req *pb.ListArticlesRequest) (*pb.ListArticlesResponse, error) {
+	cnt := atomic.AddInt64(&s.calls, 1)
+	if cnt <= s.slowCalls {
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if req.Author == "invalid" {
+		return nil, status.Error(codes.InvalidArgument, "参数错误")
+	}
+	if rateLimited, ok := ctx.Value("RateLimited").(bool); ok && rateLimited {
+		if req.Author == "notfound" {
+			// 限流的时候也可能返回业务错误
+			return nil, status.Error(codes.NotFound, "数据不存在")
+		}
+		return nil, status.Error(codes.ResourceExhausted, "数据不存在redis")
+	}
+	return &pb.ListArticlesResponse{Articles: []*pb.Article{{Id: 1, Author: req.Author}}}, nil
+}
+
+func startServer(t *testing.T, l Limiter, svc *stubArticleService, opts RetryOptions) pb.ArticleServiceClient {
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.UnaryInterceptor(UnaryServerInterceptor(l)))
+	pb.RegisterArticleServiceServer(server, svc)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(UnaryClientRetryInterceptor(opts)))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return pb.NewArticleServiceClient(conn)
+}
+
+func TestUnaryClientRetryInterceptor(t *testing.T) {
+	testCases := []struct {
+		name      string
+		limiter   *rejectLimiter
+		slowCalls int64
+		author    string
+		opts      RetryOptions
+		timeout   time.Duration
+
+		wantCode  codes.Code
+		wantCalls int64
+		minCost   time.Duration
+	}{
+		{
+			name:      "限流之后按照retry-after重试成功",
+			limiter:   &rejectLimiter{rejectCnt: 2, retryAfter: time.Millisecond * 50},
+			opts:      RetryOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			wantCode:  codes.OK,
+			wantCalls: 3,
+			minCost:   time.Millisecond * 100,
+		},
+		{
+			name:      "一直被限流，重试次数用完",
+			limiter:   &rejectLimiter{rejectCnt: 100},
+			opts:      RetryOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			wantCode:  codes.ResourceExhausted,
+			wantCalls: 3,
+		},
+		{
+			name:    "重试预算用完就不再重试",
+			limiter: &rejectLimiter{rejectCnt: 100},
+			// 10 个令牌，用掉 5 个之后就不到一半了
+			opts:      RetryOptions{MaxAttempts: 10, InitialBackoff: time.Millisecond, Budget: NewRetryBudget(10, 0.1)},
+			wantCode:  codes.ResourceExhausted,
+			wantCalls: 5,
+		},
+		{
+			name:      "不可重试的错误",
+			limiter:   &rejectLimiter{},
+			author:    "invalid",
+			opts:      RetryOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			wantCode:  codes.InvalidArgument,
+			wantCalls: 1,
+		},
+		{
+			name:      "带着限流header的业务错误不重试",
+			limiter:   &rejectLimiter{rejectCnt: 100},
+			author:    "notfound",
+			opts:      RetryOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			wantCode:  codes.NotFound,
+			wantCalls: 1,
+		},
+		{
+			name:      "单次调用超时之后重试",
+			limiter:   &rejectLimiter{},
+			slowCalls: 1,
+			opts:      RetryOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond, PerCallTimeout: time.Millisecond * 100},
+			wantCode:  codes.OK,
+			wantCalls: 2,
+		},
+		{
+			name:    "整体超时不够等到下一次重试",
+			limiter: &rejectLimiter{rejectCnt: 100, retryAfter: time.Second},
+			opts:    RetryOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			timeout: time.Millisecond * 200,
+			// 第一次就返回了，不会傻等一秒
+			wantCode:  codes.ResourceExhausted,
+			wantCalls: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := &stubArticleService{slowCalls: tc.slowCalls}
+			client := startServer(t, tc.limiter, svc, tc.opts)
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			author := tc.author
+			if author == "" {
+				author = "retry"
+			}
+			start := time.Now()
+			_, err := client.ListArticles(ctx, &pb.ListArticlesRequest{Author: author})
+			assert.Equal(t, tc.wantCode, status.Code(err))
+			assert.Equal(t, tc.wantCalls, atomic.LoadInt64(&svc.calls))
+			assert.GreaterOrEqual(t, time.Since(start), tc.minCost)
+		})
+	}
+}
+
+func TestUnaryClientRetryInterceptor_CallOptions(t *testing.T) {
+	interceptor := UnaryClientRetryInterceptor(RetryOptions{MaxAttempts: 1})
+	// 调用方的切片还有多余的容量，拦截器不能写进去
+	callOpts := make([]grpc.CallOption, 1, 2)
+	callOpts[0] = grpc.EmptyCallOption{}
+	var got int
+	err := interceptor(context.Background(), "/test", nil, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			got = len(opts)
+			return nil
+		}, callOpts...)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got)
+	assert.Nil(t, callOpts[:2][1])
+}
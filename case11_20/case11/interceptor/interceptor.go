@@ -3,14 +3,52 @@ package interceptor
 import (
 	"context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"strconv"
+	"time"
 )
 
-func UnaryServerInterceptor(tb *TokenBucket) grpc.UnaryServerInterceptor {
+const (
+	// HeaderRateLimited 请求被限流的时候服务端会在 header 里面带上它，
+	// 即便请求最终从缓存返回了数据也会带上，客户端可以据此知道服务端处于降级状态
+	HeaderRateLimited = "x-rate-limited"
+	// HeaderRetryAfter 建议客户端多久之后重试，单位毫秒
+	HeaderRetryAfter = "retry-after-ms"
+)
+
+// Limiter 限流器，令牌桶和自适应限流器都实现了这个接口
+type Limiter interface {
+	// Allow 返回 false 表示请求被限流。
+	// 返回 true 的时候，请求处理完之后必须调用 done，自适应限流器依赖它统计响应时间
+	Allow() (done func(), ok bool)
+}
+
+// RetryAfterProvider 能够估算多久之后可以重试的限流器
+type RetryAfterProvider interface {
+	RetryAfter() time.Duration
+}
+
+func UnaryServerInterceptor(l Limiter) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		if !tb.Consume(1) {
+		done, ok := l.Allow()
+		if !ok {
 			ctx = context.WithValue(ctx, "RateLimited", true)
+			setRateLimitedHeader(ctx, l)
+		} else {
+			defer done()
 		}
 		// 继续处理请求
 		return handler(ctx, req)
 	}
 }
+
+func setRateLimitedHeader(ctx context.Context, l Limiter) {
+	md := metadata.Pairs(HeaderRateLimited, "true")
+	if p, ok := l.(RetryAfterProvider); ok {
+		if d := p.RetryAfter(); d > 0 {
+			md.Append(HeaderRetryAfter, strconv.FormatInt(d.Milliseconds(), 10))
+		}
+	}
+	// 只是提示信息，设置失败也不影响请求
+	_ = grpc.SetHeader(ctx, md)
+}
@@ -11,9 +11,18 @@ type TokenBucket struct {
 	capacity    int64 // 桶的最大容量
 	tokens      int64 // 当前令牌数
 	rate        int64 // 每秒生成的令牌数
+	rejected    int64 // 累计拒绝的请求数
 	lastUpdated time.Time
 }
 
+// TokenBucketStats 令牌桶的当前状态
+type TokenBucketStats struct {
+	Capacity int64 `json:"capacity"`
+	Rate     int64 `json:"rate"`
+	Tokens   int64 `json:"tokens"`
+	Rejected int64 `json:"rejected"`
+}
+
 // NewTokenBucket 创建一个新的令牌桶限流器
 func NewTokenBucket(capacity, rate int64) *TokenBucket {
 	return &TokenBucket{
@@ -29,34 +38,87 @@ func (tb *TokenBucket) Consume(tokens int64) bool {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
-	// 计算自从上次更新以来应该添加的令牌数量
+	tb.refill()
+
+	if tb.tokens < tokens {
+		tb.rejected++
+		return false // 不足，拒绝请求
+	}
+
+	tb.tokens -= tokens
+	return true // 允许请求
+}
+
+// refill 计算自从上次更新以来应该添加的令牌数量，调用方需要持有锁
+func (tb *TokenBucket) refill() {
 	tokenToAdd := tb.rate * int64(time.Since(tb.lastUpdated).Seconds())
 	if tokenToAdd > 0 {
 		tb.tokens = min(tb.capacity, tb.tokens+tokenToAdd)
 		tb.lastUpdated = time.Now()
 	}
+}
 
-	if tb.tokens < tokens {
-		return false // 不足，拒绝请求
+// Update 运行时修改容量和速率，当前令牌数超过新容量的部分会被丢弃
+func (tb *TokenBucket) Update(capacity, rate int64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill()
+	// 按照旧速率补充完了，新速率从现在开始算。
+	// 不然速率从 0 改成 N 的时候，会把之前的时间全部按照 N 补上
+	tb.lastUpdated = time.Now()
+	tb.capacity = capacity
+	tb.rate = rate
+	tb.tokens = min(tb.tokens, capacity)
+}
+
+// Stats 返回令牌桶的当前状态
+func (tb *TokenBucket) Stats() TokenBucketStats {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill()
+	return TokenBucketStats{
+		Capacity: tb.capacity,
+		Rate:     tb.rate,
+		Tokens:   tb.tokens,
+		Rejected: tb.rejected,
 	}
+}
 
-	tb.tokens -= tokens
-	return true // 允许请求
+// Saturated 令牌已经用完，新的请求都会被限流
+func (tb *TokenBucket) Saturated() bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill()
+	return tb.tokens <= 0
+}
+
+// Allow 消费一个令牌
+func (tb *TokenBucket) Allow() (func(), bool) {
+	return func() {}, tb.Consume(1)
+}
+
+// RetryAfter 估算多久之后会有新的令牌，不会再产生令牌的时候返回 0
+func (tb *TokenBucket) RetryAfter() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.rate <= 0 {
+		return 0
+	}
+	// 令牌是按整秒补充的
+	elapsed := time.Since(tb.lastUpdated) % time.Second
+	return time.Second - elapsed
 }
 
 // Tokens 返回还剩余多少令牌
 func (tb *TokenBucket) Tokens() int64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
 	return tb.tokens
 }
 
 // Add 往令牌桶手动添加令牌 仅用于测试
 func (tb *TokenBucket) Add(count int64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
 	tb.tokens += count
 }
-
-func min(a, b int64) int64 {
-	if a < b {
-		return a
-	}
-	return b
-}
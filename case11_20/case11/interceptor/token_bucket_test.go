@@ -0,0 +1,44 @@
+package interceptor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket_Update(t *testing.T) {
+	testCases := []struct {
+		name string
+		// rate 修改之前的速率
+		rate       int64
+		idle       time.Duration
+		newRate    int64
+		wantTokens int64
+	}{
+		{
+			name:       "速率从0改成N",
+			rate:       0,
+			idle:       time.Hour,
+			newRate:    5,
+			wantTokens: 0,
+		},
+		{
+			name: "低速率空闲很久之后调高",
+			rate: 1,
+			// 按照旧速率补充 3 个
+			idle:       time.Second*3 + time.Millisecond*500,
+			newRate:    100,
+			wantTokens: 3,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tb := NewTokenBucket(10, tc.rate)
+			assert.True(t, tb.Consume(10))
+			tb.lastUpdated = time.Now().Add(-tc.idle)
+			tb.Update(10, tc.newRate)
+			assert.Equal(t, tc.wantTokens, tb.Stats().Tokens)
+		})
+	}
+}
@@ -2,16 +2,44 @@ package service
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/redis/go-redis/v9"
+	"interview-cases/case11_20/case11/cache"
 	"interview-cases/case11_20/case11/pb"
+	"log/slog"
+	"strconv"
+	"sync"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
 )
 
+const (
+	// DefaultPageSize 没有指定 page_size 时的每页数量
+	DefaultPageSize = 20
+	// MaxPageSize 每页最多返回的数量，避免一次性加载过多数据
+	MaxPageSize = 100
+
+	// DefaultLogicalTTL 逻辑过期时间，过了之后数据依旧返回，但是会触发异步刷新
+	DefaultLogicalTTL = time.Minute * 10
+	// DefaultPhysicalTTL redis 里面真正的过期时间，要比逻辑过期时间长得多
+	DefaultPhysicalTTL = time.Hour
+	// refreshTimeout 异步刷新缓存的超时时间
+	refreshTimeout = time.Second * 3
+	// unlockTimeout 释放刷新锁的超时时间
+	unlockTimeout = time.Second
+)
+
+// ErrInvalidPageToken 直接返回给 gRPC 调用方，所以用 InvalidArgument 的状态码
+var ErrInvalidPageToken = status.Error(codes.InvalidArgument, "page_token 无效")
+
 type Article struct {
 	ID      int32
 	Title   string
@@ -23,68 +51,279 @@ type ArticleService struct {
 	pb.UnimplementedArticleServiceServer
 	Client redis.Cmdable
 	DB     *gorm.DB
+	// Local 进程内的一级缓存，为 nil 的时候只用 redis
+	Local *cache.LocalCache
+
+	LogicalTTL  time.Duration
+	PhysicalTTL time.Duration
+	// Codec 写缓存使用的编码，读缓存的时候根据版本号自动选择
+	Codec Codec
+	// HotKeys 热点探测，为 nil 的时候所有 key 都会放进本地缓存，
+	// 不为 nil 的时候只有热点 key 才会放进本地缓存
+	HotKeys *cache.HotKeyDetector
+	// HotKeyReplicas 热点 key 在 redis 里面复制的份数，0 表示不复制
+	HotKeyReplicas int
+	// refreshing 正在异步刷新的 key，保证同一个 key 同时只有一个刷新
+	refreshing sync.Map
+}
+
+// CachedArticleList 缓存里面存储的值，带上了逻辑过期时间
+type CachedArticleList struct {
+	// ExpireAt 逻辑过期时间，毫秒时间戳
+	ExpireAt int64                    `json:"expire_at"`
+	Data     *pb.ListArticlesResponse `json:"data"`
+}
+
+// Stale 是否已经逻辑过期
+func (c *CachedArticleList) Stale(now time.Time) bool {
+	return now.UnixMilli() >= c.ExpireAt
+}
+
+type ArticleServiceOption func(s *ArticleService)
+
+// WithLocalCache 在 redis 前面加一层本地缓存，热点作者直接从内存返回
+func WithLocalCache(lc *cache.LocalCache) ArticleServiceOption {
+	return func(s *ArticleService) {
+		s.Local = lc
+	}
+}
+
+// WithCacheTTL 设置逻辑过期时间和 redis 里面的物理过期时间
+func WithCacheTTL(logical, physical time.Duration) ArticleServiceOption {
+	return func(s *ArticleService) {
+		s.LogicalTTL = logical
+		s.PhysicalTTL = physical
+	}
+}
+
+// WithCodec 设置写缓存使用的编码
+func WithCodec(c Codec) ArticleServiceOption {
+	return func(s *ArticleService) {
+		s.Codec = c
+	}
 }
 
-func NewArticleService(client redis.Cmdable, DB *gorm.DB) *ArticleService {
-	return &ArticleService{Client: client, DB: DB}
+func NewArticleService(client redis.Cmdable, DB *gorm.DB, opts ...ArticleServiceOption) *ArticleService {
+	s := &ArticleService{
+		Client:      client,
+		DB:          DB,
+		LogicalTTL:  DefaultLogicalTTL,
+		PhysicalTTL: DefaultPhysicalTTL,
+		Codec:       ProtoCodec{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *ArticleService) ListArticles(ctx context.Context, req *pb.ListArticlesRequest) (*pb.ListArticlesResponse, error) {
+	pageSize := normalizePageSize(req.PageSize)
+	cursor, err := decodePageToken(req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+
 	// 不管有没有限流，redis都是必须查询的
-	key := "article:" + req.Author
-	resp, err := s.getArticleListFromRedis(ctx, key)
+	// 每一页单独缓存，大作者也不会把所有文章塞进一个 key 里面
+	key := ArticlePageKey(req.Author, pageSize, req.PageToken)
+	rateLimited, _ := ctx.Value("RateLimited").(bool)
+	cached, err := s.getArticleListFromCache(ctx, key)
 	if err == nil {
-		return resp, nil
+		// 逻辑过期了也直接返回旧数据，后台刷新。
+		// 限流的时候不刷新，旧数据可以一直用到物理过期
+		if cached.Stale(time.Now()) && !rateLimited {
+			s.refreshAsync(key, req.Author, cursor, pageSize)
+		}
+		return cached.Data, nil
 	}
 
 	// 请求被限流
-	if rateLimited, ok := ctx.Value("RateLimited").(bool); ok && rateLimited {
-		return nil, errors.New("数据不存在redis")
+	if rateLimited {
+		// 用 ResourceExhausted，客户端可以按照 retry-after 重试
+		return nil, status.Error(codes.ResourceExhausted, "数据不存在redis")
 	}
 
-	resp, err = s.getArticleListFromMySQL(ctx, req.Author)
+	resp, err := s.getArticleListFromMySQL(ctx, req.Author, cursor, pageSize)
 	if err == nil {
 		// 回写redis
-		s.setArticleListToRedis(ctx, key, resp.Articles)
+		s.setArticleListToRedis(ctx, key, resp)
 	}
 	return resp, err
 }
 
-func (s *ArticleService) getArticleListFromRedis(ctx context.Context, key string) (*pb.ListArticlesResponse, error) {
+// ArticlePageKey 某个作者某一页的缓存 key
+func ArticlePageKey(author string, pageSize int32, pageToken string) string {
+	return fmt.Sprintf("%s%d:%s", authorKeyPrefix(author), normalizePageSize(pageSize), pageToken)
+}
+
+// authorKeyPrefix 某个作者所有分页缓存 key 的公共前缀。
+// 作者名字前面带上长度，tom 的前缀就不会匹配到 tom:20 的 key
+func authorKeyPrefix(author string) string {
+	return "article:" + strconv.Itoa(len(author)) + ":" + author + ":"
+}
+
+func normalizePageSize(pageSize int32) int32 {
+	if pageSize <= 0 {
+		return DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		return MaxPageSize
+	}
+	return pageSize
+}
+
+// encodePageToken 游标就是上一页最后一篇文章的 id，编码一下避免调用方依赖具体格式
+func encodePageToken(lastID int32) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(int64(lastID), 10)))
+}
+
+func decodePageToken(token string) (int32, error) {
+	if token == "" {
+		return 0, nil
+	}
+	val, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, ErrInvalidPageToken
+	}
+	id, err := strconv.ParseInt(string(val), 10, 32)
+	if err != nil || id < 0 {
+		return 0, ErrInvalidPageToken
+	}
+	return int32(id), nil
+}
+
+// refreshAsync 异步从 MySQL 重建缓存。
+// 本实例内用 refreshing 去重，多个实例之间用 redis 的 SETNX 保证只有一个实例去查 MySQL
+func (s *ArticleService) refreshAsync(key, author string, cursor, pageSize int32) {
+	if _, loaded := s.refreshing.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	go func() {
+		defer s.refreshing.Delete(key)
+		// 请求的 ctx 很快就会结束，不能复用
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+		lockKey := "refresh:" + key
+		token, ok, err := s.lockRefresh(ctx, lockKey)
+		if err != nil || !ok {
+			return
+		}
+		defer s.unlockRefresh(lockKey, token)
+		resp, err := s.getArticleListFromMySQL(ctx, author, cursor, pageSize)
+		if err != nil {
+			slog.Error("刷新缓存失败", slog.String("key", key), slog.Any("err", err))
+			return
+		}
+		if err = s.setArticleListToRedis(ctx, key, resp); err != nil {
+			slog.Error("回写缓存失败", slog.String("key", key), slog.Any("err", err))
+		}
+	}()
+}
+
+// unlockScript 锁的值还是自己的 token 才删除。
+// 刷新太慢的时候锁已经过期，被别的实例拿到了，不能把别人的锁删掉
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// lockRefresh 加刷新锁，值是随机的 token，释放的时候用来确认锁还是自己的
+func (s *ArticleService) lockRefresh(ctx context.Context, lockKey string) (string, bool, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", false, err
+	}
+	token := hex.EncodeToString(buf)
+	ok, err := s.Client.SetNX(ctx, lockKey, token, refreshTimeout).Result()
+	return token, ok, err
+}
+
+// unlockRefresh 释放刷新锁。刷新用的 ctx 可能已经超时了，这里单独设置超时
+func (s *ArticleService) unlockRefresh(lockKey, token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+	defer cancel()
+	if err := unlockScript.Run(ctx, s.Client, []string{lockKey}, token).Err(); err != nil {
+		slog.Error("释放刷新锁失败", slog.String("key", lockKey), slog.Any("err", err))
+	}
+}
+
+// getArticleListFromCache 先查本地缓存，再查 redis，redis 命中了就回填本地缓存
+func (s *ArticleService) getArticleListFromCache(ctx context.Context, key string) (*CachedArticleList, error) {
+	hot := s.HotKeys != nil && s.HotKeys.Record(key)
+	useLocal := s.useLocal(hot)
+	if useLocal {
+		if res, ok := s.Local.Get(key); ok {
+			return s.decodeArticleList(res)
+		}
+	}
 	// 从 Redis 获取文章列表
-	res, err := s.Client.Get(ctx, key).Bytes()
+	res, err := s.getFromRedis(ctx, key, hot)
 	if err != nil {
 		return nil, err
 	}
-	var articles []*pb.Article
-	er := json.Unmarshal(res, &articles)
+	resp, err := s.decodeArticleList(res)
+	if err != nil {
+		return nil, err
+	}
+	if useLocal {
+		s.Local.Set(key, res)
+	}
+	return resp, nil
+}
 
-	if er != nil {
-		return nil, er
+func (s *ArticleService) decodeArticleList(res []byte) (*CachedArticleList, error) {
+	resp, err := DecodeCacheValue(res)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Data == nil {
+		return nil, errors.New("缓存数据格式错误")
 	}
 
-	return &pb.ListArticlesResponse{
-		Articles: articles,
-	}, nil
+	return resp, nil
 }
 
-func (s *ArticleService) setArticleListToRedis(ctx context.Context, key string, val []*pb.Article) error {
-	value, _ := json.Marshal(val)
-	return s.Client.Set(ctx, key, value, time.Minute*10).Err()
+func (s *ArticleService) setArticleListToRedis(ctx context.Context, key string, val *pb.ListArticlesResponse) error {
+	value, err := EncodeCacheValue(s.Codec, &CachedArticleList{
+		ExpireAt: time.Now().Add(s.LogicalTTL).UnixMilli(),
+		Data:     val,
+	})
+	if err != nil {
+		return err
+	}
+	hot := s.HotKeys != nil && s.HotKeys.IsHot(key)
+	err = s.setToRedis(ctx, key, value, hot)
+	if err == nil && s.useLocal(hot) {
+		s.Local.Set(key, value)
+	}
+	return err
 
 }
 
-func (s *ArticleService) getArticleListFromMySQL(ctx context.Context, author string) (*pb.ListArticlesResponse, error) {
-	// 从 MySQL 获取文章列表
-	// 假设这里返回了一个空的响应
+func (s *ArticleService) getArticleListFromMySQL(ctx context.Context, author string, cursor int32, pageSize int32) (*pb.ListArticlesResponse, error) {
+	// 从 MySQL 获取一页文章，多查一条用来判断是否还有下一页
 	var articles []Article
-	err := s.DB.WithContext(ctx).Model(Article{}).Where("author = ?", author).Find(&articles).Error
+	err := s.DB.WithContext(ctx).Model(Article{}).
+		Where("author = ? AND id > ?", author, cursor).
+		Order("id ASC").
+		Limit(int(pageSize) + 1).
+		Find(&articles).Error
 	if err != nil {
 		return nil, err
 	}
 
+	var nextPageToken string
+	if len(articles) > int(pageSize) {
+		articles = articles[:pageSize]
+		nextPageToken = encodePageToken(articles[len(articles)-1].ID)
+	}
+
 	return &pb.ListArticlesResponse{
-		Articles: toProto(articles),
+		Articles:      toProto(articles),
+		NextPageToken: nextPageToken,
 	}, nil
 }
 
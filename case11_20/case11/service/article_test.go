@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"interview-cases/case11_20/case11/pb"
+	"interview-cases/test"
+)
+
+func TestArticleService_InvalidPageToken(t *testing.T) {
+	testCases := []struct {
+		name  string
+		token string
+	}{
+		{name: "不是 base64", token: "!!!"},
+		{name: "不是数字", token: base64.RawURLEncoding.EncodeToString([]byte("abc"))},
+		{name: "负数", token: base64.RawURLEncoding.EncodeToString([]byte("-1"))},
+	}
+	// page_token 错误的时候不会访问 redis 和 MySQL
+	svc := NewArticleService(nil, nil)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := svc.ListArticles(context.Background(), &pb.ListArticlesRequest{
+				Author:    "tom",
+				PageToken: tc.token,
+			})
+			assert.ErrorIs(t, err, ErrInvalidPageToken)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
+
+// TestArticleService_RefreshLock 锁过期之后被别的实例拿到了，释放的时候不能把别人的锁删掉
+func TestArticleService_RefreshLock(t *testing.T) {
+	client := test.InitRedis()
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skip("redis 不可用", err)
+	}
+	svc := NewArticleService(client, nil)
+	lockKey := "refresh:test_refresh_lock"
+	require.NoError(t, client.Del(ctx, lockKey).Err())
+	defer client.Del(ctx, lockKey)
+
+	token, ok, err := svc.lockRefresh(ctx, lockKey)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, ok, err = svc.lockRefresh(ctx, lockKey)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// 模拟锁过期之后被别的实例拿到
+	require.NoError(t, client.Set(ctx, lockKey, "other", time.Minute).Err())
+	svc.unlockRefresh(lockKey, token)
+	val, err := client.Get(ctx, lockKey).Result()
+	require.NoError(t, err)
+	assert.Equal(t, "other", val)
+
+	svc.unlockRefresh(lockKey, "other")
+	assert.Equal(t, int64(0), client.Exists(ctx, lockKey).Val())
+}
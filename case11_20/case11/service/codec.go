@@ -0,0 +1,152 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+	"interview-cases/case11_20/case11/pb"
+)
+
+// 缓存值的第一个字节是编码版本，解码的时候根据版本选择 Codec，
+// 这样换了编码方式之后，redis 里面旧格式的数据依旧能读出来
+const (
+	CodecVersionJSON            byte = 1
+	CodecVersionProto           byte = 2
+	CodecVersionCompressedProto byte = 3
+
+	// legacyJSONPrefix 没有版本号的时候缓存里面直接存的是 JSON
+	legacyJSONPrefix = '{'
+)
+
+var (
+	ErrEmptyCacheValue     = errors.New("缓存值为空")
+	ErrUnknownCodecVersion = errors.New("未知的缓存编码版本")
+)
+
+// Codec 缓存值的编解码
+type Codec interface {
+	// Version 编码版本，不同的 Codec 必须不同
+	Version() byte
+	Encode(val *CachedArticleList) ([]byte, error)
+	Decode(data []byte) (*CachedArticleList, error)
+}
+
+var codecs = map[byte]Codec{}
+
+func init() {
+	for _, c := range []Codec{JSONCodec{}, ProtoCodec{}, CompressedProtoCodec{}} {
+		codecs[c.Version()] = c
+	}
+}
+
+// EncodeCacheValue 使用 c 编码，并且在最前面写上版本号
+func EncodeCacheValue(c Codec, val *CachedArticleList) ([]byte, error) {
+	data, err := c.Encode(val)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]byte, 0, len(data)+1)
+	res = append(res, c.Version())
+	return append(res, data...), nil
+}
+
+// DecodeCacheValue 根据版本号选择 Codec 解码，兼容没有版本号的 JSON
+func DecodeCacheValue(data []byte) (*CachedArticleList, error) {
+	if len(data) == 0 {
+		return nil, ErrEmptyCacheValue
+	}
+	if data[0] == legacyJSONPrefix {
+		return JSONCodec{}.Decode(data)
+	}
+	c, ok := codecs[data[0]]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownCodecVersion, data[0])
+	}
+	return c.Decode(data[1:])
+}
+
+// JSONCodec 最早的编码方式
+type JSONCodec struct{}
+
+func (JSONCodec) Version() byte {
+	return CodecVersionJSON
+}
+
+func (JSONCodec) Encode(val *CachedArticleList) ([]byte, error) {
+	return json.Marshal(val)
+}
+
+func (JSONCodec) Decode(data []byte) (*CachedArticleList, error) {
+	var val CachedArticleList
+	err := json.Unmarshal(data, &val)
+	return &val, err
+}
+
+// ProtoCodec 前 8 个字节是逻辑过期时间，后面是 protobuf 编码的 ListArticlesResponse
+type ProtoCodec struct{}
+
+func (ProtoCodec) Version() byte {
+	return CodecVersionProto
+}
+
+func (ProtoCodec) Encode(val *CachedArticleList) ([]byte, error) {
+	buf := binary.BigEndian.AppendUint64(make([]byte, 0, 64), uint64(val.ExpireAt))
+	return proto.MarshalOptions{}.MarshalAppend(buf, val.Data)
+}
+
+func (ProtoCodec) Decode(data []byte) (*CachedArticleList, error) {
+	if len(data) < 8 {
+		return nil, errors.New("缓存数据格式错误")
+	}
+	var resp pb.ListArticlesResponse
+	err := proto.Unmarshal(data[8:], &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &CachedArticleList{
+		ExpireAt: int64(binary.BigEndian.Uint64(data[:8])),
+		Data:     &resp,
+	}, nil
+}
+
+// CompressedProtoCodec 在 ProtoCodec 的基础上用 gzip 压缩，适合内容很长的文章
+type CompressedProtoCodec struct{}
+
+func (CompressedProtoCodec) Version() byte {
+	return CodecVersionCompressedProto
+}
+
+func (CompressedProtoCodec) Encode(val *CachedArticleList) ([]byte, error) {
+	data, err := ProtoCodec{}.Encode(val)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (CompressedProtoCodec) Decode(data []byte) (*CachedArticleList, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ProtoCodec{}.Decode(raw)
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"interview-cases/case11_20/case11/pb"
+)
+
+func TestCodec(t *testing.T) {
+	val := newCachedArticleList(3, 100)
+	testCases := []struct {
+		name  string
+		codec Codec
+	}{
+		{name: "json", codec: JSONCodec{}},
+		{name: "protobuf", codec: ProtoCodec{}},
+		{name: "压缩的protobuf", codec: CompressedProtoCodec{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := EncodeCacheValue(tc.codec, val)
+			require.NoError(t, err)
+			assert.Equal(t, tc.codec.Version(), data[0])
+			res, err := DecodeCacheValue(data)
+			require.NoError(t, err)
+			assert.Equal(t, val.ExpireAt, res.ExpireAt)
+			assert.True(t, proto.Equal(val.Data, res.Data))
+		})
+	}
+}
+
+func TestDecodeCacheValue(t *testing.T) {
+	val := newCachedArticleList(1, 10)
+
+	// 没有版本号的旧数据
+	legacy, err := json.Marshal(val)
+	require.NoError(t, err)
+	res, err := DecodeCacheValue(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, val.ExpireAt, res.ExpireAt)
+	assert.True(t, proto.Equal(val.Data, res.Data))
+
+	_, err = DecodeCacheValue(nil)
+	assert.Equal(t, ErrEmptyCacheValue, err)
+
+	_, err = DecodeCacheValue([]byte{100, 1, 2})
+	assert.ErrorIs(t, err, ErrUnknownCodecVersion)
+}
+
+func BenchmarkCodec(b *testing.B) {
+	codecs := []Codec{JSONCodec{}, ProtoCodec{}, CompressedProtoCodec{}}
+	for _, cnt := range []int{1, 20, 100} {
+		val := newCachedArticleList(cnt, 2000)
+		for _, c := range codecs {
+			data, err := EncodeCacheValue(c, val)
+			require.NoError(b, err)
+			name := fmt.Sprintf("%T/articles_%d", c, cnt)
+			b.Run(name+"/encode", func(b *testing.B) {
+				b.ReportMetric(float64(len(data)), "bytes")
+				for i := 0; i < b.N; i++ {
+					_, _ = EncodeCacheValue(c, val)
+				}
+			})
+			b.Run(name+"/decode", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					_, _ = DecodeCacheValue(data)
+				}
+			})
+		}
+	}
+}
+
+func newCachedArticleList(cnt int, contentLen int) *CachedArticleList {
+	articles := make([]*pb.Article, 0, cnt)
+	for i := 0; i < cnt; i++ {
+		articles = append(articles, &pb.Article{
+			Id:      int32(i + 1),
+			Title:   fmt.Sprintf("标题%d", i),
+			Author:  "codec",
+			Content: strings.Repeat("文章内容", contentLen/4),
+		})
+	}
+	return &CachedArticleList{
+		ExpireAt: time.Now().UnixMilli(),
+		Data:     &pb.ListArticlesResponse{Articles: articles},
+	}
+}
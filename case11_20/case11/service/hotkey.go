@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"interview-cases/case11_20/case11/cache"
+)
+
+// WithHotKeyDetector 开启热点探测，热点 key 会自动放进本地缓存。
+// replicas 大于 0 的时候，热点 key 在 redis 里面还会复制成 replicas 份，
+// 读的时候随机选一份，避免所有流量都打到同一个 redis 节点上
+func WithHotKeyDetector(d *cache.HotKeyDetector, replicas int) ArticleServiceOption {
+	return func(s *ArticleService) {
+		s.HotKeys = d
+		s.HotKeyReplicas = replicas
+	}
+}
+
+// replicaKey 热点 key 的副本，和原本的 key 有相同的前缀，删除作者缓存的时候会一起删掉
+func replicaKey(key string, idx int) string {
+	return key + "#" + strconv.Itoa(idx)
+}
+
+// replicaTTL 副本的过期时间。key 不再是热点之后副本就不会更新了，
+// 所以副本只保留一个逻辑过期时间，旧数据最多被读这么久
+func (s *ArticleService) replicaTTL() time.Duration {
+	if s.LogicalTTL > 0 && s.LogicalTTL < s.PhysicalTTL {
+		return s.LogicalTTL
+	}
+	return s.PhysicalTTL
+}
+
+func (s *ArticleService) useLocal(hot bool) bool {
+	return s.Local != nil && (s.HotKeys == nil || hot)
+}
+
+// getFromRedis 热点 key 随机读一个副本，副本没有就读原本的 key，并且回填副本
+func (s *ArticleService) getFromRedis(ctx context.Context, key string, hot bool) ([]byte, error) {
+	if !hot || s.HotKeyReplicas <= 0 {
+		return s.Client.Get(ctx, key).Bytes()
+	}
+	rk := replicaKey(key, rand.Intn(s.HotKeyReplicas))
+	res, err := s.Client.Get(ctx, rk).Bytes()
+	if err == nil || !errors.Is(err, redis.Nil) {
+		return res, err
+	}
+	res, err = s.Client.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	s.Client.Set(ctx, rk, res, s.replicaTTL())
+	return res, nil
+}
+
+// setToRedis 热点 key 同时更新所有的副本。
+// 不是热点的 key 删掉可能残留的副本，避免 key 降级之后还有请求读到旧的副本
+func (s *ArticleService) setToRedis(ctx context.Context, key string, value []byte, hot bool) error {
+	if s.HotKeyReplicas <= 0 {
+		return s.Client.Set(ctx, key, value, s.PhysicalTTL).Err()
+	}
+	_, err := s.Client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, key, value, s.PhysicalTTL)
+		for i := 0; i < s.HotKeyReplicas; i++ {
+			if hot {
+				p.Set(ctx, replicaKey(key, i), value, s.replicaTTL())
+			} else {
+				p.Del(ctx, replicaKey(key, i))
+			}
+		}
+		return nil
+	})
+	return err
+}
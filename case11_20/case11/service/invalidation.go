@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/redis/go-redis/v9"
+	"interview-cases/internal/redisx"
+)
+
+// InvalidateChannel 跨实例通知删除本地缓存的频道，消息内容就是作者
+const InvalidateChannel = "article:invalidate"
+
+// Subscriber 订阅 redis 频道，*redis.Client 和 *redis.ClusterClient 都实现了
+type Subscriber interface {
+	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+}
+
+// InvalidateAuthor 作者的文章发生变化的时候调用，删除 redis 和本地的缓存，
+// 并且通知其它实例删除各自的本地缓存
+func (s *ArticleService) InvalidateAuthor(ctx context.Context, author string) error {
+	prefix := authorKeyPrefix(author)
+	iter := s.Client.Scan(ctx, 0, redisx.EscapeGlob(prefix)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		if err := s.Client.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if s.Local != nil {
+		s.Local.DeletePrefix(prefix)
+	}
+	return s.Client.Publish(ctx, InvalidateChannel, author).Err()
+}
+
+// SubscribeInvalidation 监听其它实例发出来的失效通知，删除本地缓存。
+// 会一直阻塞到 ctx 被取消
+func (s *ArticleService) SubscribeInvalidation(ctx context.Context, sub Subscriber) error {
+	pubsub := sub.Subscribe(ctx, InvalidateChannel)
+	defer pubsub.Close()
+	// 确认订阅成功，避免漏掉刚发出来的通知
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	ch := pubsub.Channel()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if s.Local != nil {
+				cnt := s.Local.DeletePrefix(authorKeyPrefix(msg.Payload))
+				slog.Info("收到失效通知，删除本地缓存", slog.String("author", msg.Payload), slog.Int("cnt", cnt))
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WarmAuthor 从 MySQL 加载作者的前 pages 页写到缓存里面，返回实际预热的页数
+func (s *ArticleService) WarmAuthor(ctx context.Context, author string, pageSize int32, pages int) (int, error) {
+	pageSize = normalizePageSize(pageSize)
+	var (
+		cursor    int32
+		pageToken string
+	)
+	for i := 0; i < pages; i++ {
+		resp, err := s.getArticleListFromMySQL(ctx, author, cursor, pageSize)
+		if err != nil {
+			return i, err
+		}
+		if err = s.setArticleListToRedis(ctx, ArticlePageKey(author, pageSize, pageToken), resp); err != nil {
+			return i, err
+		}
+		if resp.NextPageToken == "" {
+			return i + 1, nil
+		}
+		pageToken = resp.NextPageToken
+		cursor, err = decodePageToken(pageToken)
+		if err != nil {
+			return i + 1, err
+		}
+	}
+	return pages, nil
+}
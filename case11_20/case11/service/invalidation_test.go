@@ -0,0 +1,30 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorKeyPrefix(t *testing.T) {
+	testCases := []struct {
+		name   string
+		author string
+		// other 另外一个作者，它的 key 不能以 author 的前缀开头
+		other string
+	}{
+		{name: "名字带冒号", author: "tom", other: "tom:20"},
+		{name: "名字是前缀", author: "tom", other: "tomas"},
+		{name: "名字带通配符", author: "a*", other: "a*b"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			prefix := authorKeyPrefix(tc.author)
+			assert.True(t, strings.HasPrefix(ArticlePageKey(tc.author, 20, "abc"), prefix))
+			for _, pageSize := range []int32{0, 1, 20, 100} {
+				assert.False(t, strings.HasPrefix(ArticlePageKey(tc.other, pageSize, ""), prefix))
+			}
+		})
+	}
+}
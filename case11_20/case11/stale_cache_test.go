@@ -0,0 +1,60 @@
+package case11
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pb2 "interview-cases/case11_20/case11/pb"
+	"interview-cases/case11_20/case11/service"
+	"interview-cases/test"
+)
+
+func TestCase11_StaleWhileRevalidate(t *testing.T) {
+	db := test.InitDB()
+	client := test.InitRedis()
+	svc := service.NewArticleService(client, db, service.WithCacheTTL(time.Minute, time.Hour))
+	err := db.AutoMigrate(&service.Article{})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	key := service.ArticlePageKey("stale", 0, "")
+	// 缓存里面是已经逻辑过期的旧数据
+	val, err := json.Marshal(&service.CachedArticleList{
+		ExpireAt: time.Now().Add(-time.Second).UnixMilli(),
+		Data: &pb2.ListArticlesResponse{Articles: []*pb2.Article{
+			{Id: 1, Title: "stale", Author: "stale", Content: "旧数据"},
+		}},
+	})
+	require.NoError(t, err)
+	err = client.Set(ctx, key, val, time.Hour).Err()
+	require.NoError(t, err)
+	err = db.Create(&service.Article{ID: 1, Title: "stale", Author: "stale", Content: "新数据"}).Error
+	require.NoError(t, err)
+	defer func() {
+		client.Del(ctx, key)
+		db.Exec("TRUNCATE TABLE `articles`")
+	}()
+
+	req := &pb2.ListArticlesRequest{Author: "stale"}
+	// 限流的时候直接返回旧数据，并且不会刷新
+	resp, err := svc.ListArticles(context.WithValue(ctx, "RateLimited", true), req)
+	require.NoError(t, err)
+	assert.Equal(t, "旧数据", resp.Articles[0].Content)
+	time.Sleep(time.Millisecond * 100)
+	resp, err = svc.ListArticles(context.WithValue(ctx, "RateLimited", true), req)
+	require.NoError(t, err)
+	assert.Equal(t, "旧数据", resp.Articles[0].Content)
+
+	// 没有限流，还是先拿到旧数据，后台刷新之后拿到新数据
+	resp, err = svc.ListArticles(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "旧数据", resp.Articles[0].Content)
+	assert.Eventually(t, func() bool {
+		resp, err = svc.ListArticles(ctx, req)
+		return err == nil && resp.Articles[0].Content == "新数据"
+	}, time.Second*3, time.Millisecond*50)
+}
@@ -0,0 +1,70 @@
+package case11
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/case11_20/case11/cache"
+	pb2 "interview-cases/case11_20/case11/pb"
+	"interview-cases/case11_20/case11/service"
+	"interview-cases/test"
+)
+
+func TestCase11_TwoLevelCache(t *testing.T) {
+	db := test.InitDB()
+	client := test.InitRedis()
+	// 模拟两个实例，各自有自己的本地缓存
+	localA := cache.NewLocalCache(100, time.Minute)
+	localB := cache.NewLocalCache(100, time.Minute)
+	svcA := service.NewArticleService(client, db, service.WithLocalCache(localA))
+	svcB := service.NewArticleService(client, db, service.WithLocalCache(localB))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = svcB.SubscribeInvalidation(ctx, client.(*redis.Client))
+	}()
+
+	key := service.ArticlePageKey("two_level", 0, "")
+	val, err := json.Marshal(&service.CachedArticleList{
+		ExpireAt: time.Now().Add(time.Minute).UnixMilli(),
+		Data: &pb2.ListArticlesResponse{Articles: []*pb2.Article{
+			{Id: 1, Title: "two_level", Author: "two_level", Content: "二级缓存"},
+		}},
+	})
+	require.NoError(t, err)
+	err = client.Set(ctx, key, val, time.Minute).Err()
+	require.NoError(t, err)
+	defer client.Del(context.Background(), key)
+
+	req := &pb2.ListArticlesRequest{Author: "two_level"}
+	_, err = svcA.ListArticles(ctx, req)
+	require.NoError(t, err)
+	_, err = svcB.ListArticles(ctx, req)
+	require.NoError(t, err)
+	_, ok := localB.Get(key)
+	assert.True(t, ok)
+
+	// redis 里面的数据没了，本地缓存依旧能返回
+	err = client.Del(ctx, key).Err()
+	require.NoError(t, err)
+	rateLimitedCtx := context.WithValue(ctx, "RateLimited", true)
+	resp, err := svcB.ListArticles(rateLimitedCtx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "二级缓存", resp.Articles[0].Content)
+
+	// A 实例发出失效通知，B 实例的本地缓存也会被删除
+	err = svcA.InvalidateAuthor(ctx, "two_level")
+	require.NoError(t, err)
+	_, ok = localA.Get(key)
+	assert.False(t, ok)
+	assert.Eventually(t, func() bool {
+		_, ok := localB.Get(key)
+		return !ok
+	}, time.Second, time.Millisecond*10)
+}
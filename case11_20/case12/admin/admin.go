@@ -0,0 +1,118 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"interview-cases/case11_20/case12"
+)
+
+// Handler 查看 HashRing 的槽分布和负载的 HTTP 接口
+type Handler struct {
+	ring *case12.HashRing
+}
+
+func NewHandler(ring *case12.HashRing) *Handler {
+	return &Handler{ring: ring}
+}
+
+func (h *Handler) RegisterRouter(server *gin.Engine) {
+	g := server.Group("/hashring")
+	g.GET("/nodes", h.ListNodes)
+	g.GET("/slots", h.ListSlots)
+	g.GET("/balance", h.BalanceStatus)
+}
+
+type NodeVO struct {
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Weight    int    `json:"weight"`
+	Zone      string `json:"zone,omitempty"`
+	Available bool   `json:"available"`
+	// Removed 节点已经删除，但是还有槽没有迁移完
+	Removed bool               `json:"removed,omitempty"`
+	Ranges  []case12.SlotRange `json:"ranges"`
+	Slots   int                `json:"slots"`
+	Load    float64            `json:"load"`
+}
+
+// ListNodes 每个节点负责的槽和负载
+func (h *Handler) ListNodes(c *gin.Context) {
+	s := h.ring.Snapshot()
+	loads := h.ring.SlotLoads()
+	// 已经删除但是还有槽的节点也要带上机房和可用状态
+	nodes := make(map[string]*case12.Node)
+	for _, n := range h.ring.RouteNodes() {
+		nodes[n.Name()] = n
+	}
+	res := make([]*NodeVO, 0, len(s.Nodes))
+	vos := make(map[string]*NodeVO, len(s.Nodes))
+	for _, ns := range s.Nodes {
+		vo := &NodeVO{
+			Name:    ns.Name,
+			Address: ns.Address,
+			Weight:  ns.Weight,
+			Removed: ns.Removed,
+			Ranges:  []case12.SlotRange{},
+		}
+		if n, ok := nodes[ns.Name]; ok {
+			vo.Zone = n.Zone()
+			vo.Available = n.Available()
+		}
+		res = append(res, vo)
+		vos[ns.Name] = vo
+	}
+	for _, r := range s.Ranges {
+		vo := vos[r.Node]
+		vo.Ranges = append(vo.Ranges, r.SlotRange)
+		vo.Slots += r.End - r.Start
+		vo.Load += sum(loads[r.Start:r.End])
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+type RangeVO struct {
+	case12.RangeSnapshot
+	Load float64 `json:"load"`
+}
+
+type SlotsVO struct {
+	Epoch  uint64    `json:"epoch"`
+	Ranges []RangeVO `json:"ranges"`
+	// Loads 每个槽衰减之后的负载
+	Loads []float64 `json:"loads"`
+}
+
+// ListSlots 每一段槽的归属和每个槽的负载
+func (h *Handler) ListSlots(c *gin.Context) {
+	s := h.ring.Snapshot()
+	loads := h.ring.SlotLoads()
+	res := SlotsVO{Epoch: s.Epoch, Ranges: make([]RangeVO, 0, len(s.Ranges)), Loads: loads}
+	for _, r := range s.Ranges {
+		res.Ranges = append(res.Ranges, RangeVO{RangeSnapshot: r, Load: sum(loads[r.Start:r.End])})
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+type BalanceVO struct {
+	Epoch     uint64                 `json:"epoch"`
+	Imbalance float64                `json:"imbalance"`
+	History   []case12.BalanceRecord `json:"history"`
+}
+
+// BalanceStatus 当前的不均衡度和最近的 Balance 记录
+func (h *Handler) BalanceStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, BalanceVO{
+		Epoch:     h.ring.Epoch(),
+		Imbalance: h.ring.Imbalance(),
+		History:   append([]case12.BalanceRecord{}, h.ring.BalanceHistory()...),
+	})
+}
+
+func sum(loads []float64) float64 {
+	res := 0.0
+	for _, l := range loads {
+		res += l
+	}
+	return res
+}
@@ -0,0 +1,123 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/case11_20/case12"
+)
+
+func newTestServer(t *testing.T) (*gin.Engine, *case12.HashRing) {
+	gin.SetMode(gin.TestMode)
+	a := case12.NewNode("a", "a_address", 1)
+	a.SetZone("bj")
+	b := case12.NewNode("b", "b_address", 1)
+	c := case12.NewNode("c", "c_address", 2)
+	ring := case12.NewHashRing([]*case12.Node{a, b, c}, 8, func(req any) int {
+		return req.(int) % 8
+	})
+	require.NoError(t, ring.MarkDown("b"))
+	ring.SetRequestNumOfSlot([]int{10, 10, 10, 10, 20, 20, 20, 20})
+	server := gin.New()
+	NewHandler(ring).RegisterRouter(server)
+	return server, ring
+}
+
+func TestHandler_ListNodes(t *testing.T) {
+	server, _ := newTestServer(t)
+	var res []NodeVO
+	get(t, server, "/hashring/nodes", &res)
+	require.Len(t, res, 3)
+	testCases := []struct {
+		want NodeVO
+	}{
+		{want: NodeVO{Name: "a", Address: "a_address", Weight: 1, Zone: "bj", Available: true,
+			Ranges: []case12.SlotRange{{Start: 0, End: 2}}, Slots: 2, Load: 20}},
+		{want: NodeVO{Name: "b", Address: "b_address", Weight: 1, Available: false,
+			Ranges: []case12.SlotRange{{Start: 2, End: 4}}, Slots: 2, Load: 20}},
+		{want: NodeVO{Name: "c", Address: "c_address", Weight: 2, Available: true,
+			Ranges: []case12.SlotRange{{Start: 4, End: 8}}, Slots: 4, Load: 80}},
+	}
+	for i, tc := range testCases {
+		// 负载随时间衰减，不会刚好是整数
+		assert.InDelta(t, tc.want.Load, res[i].Load, 0.01)
+		res[i].Load = tc.want.Load
+		assert.Equal(t, tc.want, res[i])
+	}
+}
+
+func TestHandler_ListNodesRemoved(t *testing.T) {
+	server, ring := newTestServer(t)
+	_, err := ring.RemoveNode("a")
+	require.NoError(t, err)
+	var res []NodeVO
+	get(t, server, "/hashring/nodes", &res)
+	require.Len(t, res, 3)
+	// 还没有迁移的节点排在最后，机房和可用状态依旧来自节点本身
+	a := res[2]
+	assert.Equal(t, "a", a.Name)
+	assert.True(t, a.Removed)
+	assert.Equal(t, "bj", a.Zone)
+	assert.True(t, a.Available)
+	assert.Equal(t, 2, a.Slots)
+}
+
+func TestHandler_ListSlots(t *testing.T) {
+	server, ring := newTestServer(t)
+	var res SlotsVO
+	get(t, server, "/hashring/slots", &res)
+	assert.Equal(t, ring.Epoch(), res.Epoch)
+	require.Len(t, res.Ranges, 3)
+	assert.Equal(t, case12.SlotRange{Start: 4, End: 8}, res.Ranges[2].SlotRange)
+	assert.Equal(t, "c", res.Ranges[2].Node)
+	assert.InDelta(t, 80, res.Ranges[2].Load, 0.01)
+	assert.Len(t, res.Loads, 8)
+	assert.InDelta(t, 20, res.Loads[7], 0.01)
+}
+
+func TestHandler_BalanceStatus(t *testing.T) {
+	server, ring := newTestServer(t)
+	// Migration 里面是节点的名字，没办法直接反序列化成 BalanceVO
+	var res struct {
+		Epoch     uint64  `json:"epoch"`
+		Imbalance float64 `json:"imbalance"`
+		History   []struct {
+			ImbalanceBefore float64 `json:"imbalance_before"`
+			ImbalanceAfter  float64 `json:"imbalance_after"`
+			MovedSlots      int     `json:"moved_slots"`
+			Migrations      []struct {
+				case12.SlotRange
+				From string `json:"from"`
+				To   string `json:"to"`
+			} `json:"migrations"`
+		} `json:"history"`
+	}
+	get(t, server, "/hashring/balance", &res)
+	assert.Empty(t, res.History)
+	// c 的 负载/权重 是 40，平均值是 30
+	assert.InDelta(t, 4.0/3, res.Imbalance, 0.01)
+
+	// c 的负载翻倍之后 Balance
+	ring.SetRequestNumOfSlot([]int{10, 10, 10, 10, 40, 40, 40, 40})
+	plan := ring.Balance()
+	get(t, server, "/hashring/balance", &res)
+	require.Len(t, res.History, 1)
+	assert.Equal(t, plan.MovedSlots(), res.History[0].MovedSlots)
+	assert.Equal(t, ring.Epoch(), res.Epoch)
+	assert.Greater(t, res.History[0].ImbalanceBefore, res.History[0].ImbalanceAfter)
+	assert.InDelta(t, res.History[0].ImbalanceAfter, res.Imbalance, 0.01)
+	assert.Equal(t, plan.Migrations[0].To.Name(), res.History[0].Migrations[0].To)
+}
+
+func get(t *testing.T, server *gin.Engine, path string, res any) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), res))
+}
@@ -0,0 +1,144 @@
+package case12
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultLRUCapacity 节点没有设置缓存的时候，默认的内存缓存的容量
+const DefaultLRUCapacity = 10000
+
+var ErrCacheMiss = errors.New("缓存不存在")
+
+// CacheBackend 节点背后真正存数据的缓存，LRUBackend、RedisBackend 和 FileBackend 都实现了
+type CacheBackend interface {
+	// Get key 不存在或者已经过期的时候返回 ErrCacheMiss
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set ttl 小于等于 0 表示永不过期
+	Set(ctx context.Context, key string, val []byte, ttl time.Duration) error
+	// Delete key 不存在的时候不返回错误
+	Delete(ctx context.Context, key string) error
+}
+
+// ScannableBackend 能够遍历所有数据的缓存，迁移槽的时候需要把旧节点上的数据找出来
+type ScannableBackend interface {
+	CacheBackend
+	// Scan 遍历所有没有过期的 key，ttl 是剩余的过期时间，0 表示永不过期。
+	// fn 返回 error 的时候停止遍历并且返回这个 error
+	Scan(ctx context.Context, fn func(key string, val []byte, ttl time.Duration) error) error
+}
+
+var (
+	_ ScannableBackend = (*LRUBackend)(nil)
+	_ ScannableBackend = (*RedisBackend)(nil)
+	_ ScannableBackend = (*FileBackend)(nil)
+)
+
+// LRUBackend 进程内的 LRU 缓存，每个 key 有自己的过期时间
+type LRUBackend struct {
+	lock     sync.Mutex
+	capacity int
+	// 越靠近头部越是最近访问的
+	ll    *list.List
+	items map[string]*list.Element
+	now   func() time.Time
+}
+
+type lruEntry struct {
+	key string
+	val []byte
+	// expireAt 零值表示永不过期
+	expireAt time.Time
+}
+
+func NewLRUBackend(capacity int) *LRUBackend {
+	return &LRUBackend{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element, capacity),
+		now:      time.Now,
+	}
+}
+
+func (c *LRUBackend) Get(_ context.Context, key string) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	e := elem.Value.(*lruEntry)
+	if !e.expireAt.IsZero() && !c.now().Before(e.expireAt) {
+		c.removeElement(elem)
+		return nil, ErrCacheMiss
+	}
+	c.ll.MoveToFront(elem)
+	return e.val, nil
+}
+
+func (c *LRUBackend) Set(_ context.Context, key string, val []byte, ttl time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = c.now().Add(ttl)
+	}
+	if elem, ok := c.items[key]; ok {
+		e := elem.Value.(*lruEntry)
+		e.val = val
+		e.expireAt = expireAt
+		c.ll.MoveToFront(elem)
+		return nil
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, val: val, expireAt: expireAt})
+	// 超过容量，淘汰最久没有访问的
+	for c.ll.Len() > c.capacity {
+		c.removeElement(c.ll.Back())
+	}
+	return nil
+}
+
+func (c *LRUBackend) Delete(_ context.Context, key string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+	return nil
+}
+
+func (c *LRUBackend) Scan(ctx context.Context, fn func(key string, val []byte, ttl time.Duration) error) error {
+	// 先拿快照，fn 里面可能会读写这个缓存
+	c.lock.Lock()
+	now := c.now()
+	entries := make([]lruEntry, 0, c.ll.Len())
+	for elem := c.ll.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(*lruEntry)
+		if e.expireAt.IsZero() || now.Before(e.expireAt) {
+			entries = append(entries, *e)
+		}
+	}
+	c.lock.Unlock()
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var ttl time.Duration
+		if !e.expireAt.IsZero() {
+			ttl = e.expireAt.Sub(now)
+		}
+		if err := fn(e.key, e.val, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *LRUBackend) removeElement(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*lruEntry).key)
+}
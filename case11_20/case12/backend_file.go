@@ -0,0 +1,131 @@
+package case12
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// filePrefix 缓存文件名的前缀，用来区分临时文件
+const filePrefix = "c_"
+
+// FileBackend 每个 key 存成 dir 下面的一个文件，文件的前 8 个字节是过期时间（UnixNano，0 表示永不过期）
+type FileBackend struct {
+	dir string
+	now func() time.Time
+}
+
+// NewFileBackend dir 不存在的时候会创建
+func NewFileBackend(dir string) (*FileBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileBackend{dir: dir, now: time.Now}, nil
+}
+
+func (f *FileBackend) Get(_ context.Context, key string) ([]byte, error) {
+	_, val, err := f.read(key)
+	return val, err
+}
+
+// read 返回过期时间和数据，不存在或者已经过期的时候返回 ErrCacheMiss
+func (f *FileBackend) read(key string) (int64, []byte, error) {
+	data, err := os.ReadFile(f.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil, ErrCacheMiss
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) < 8 {
+		// 写了一半的文件，当作不存在
+		return 0, nil, ErrCacheMiss
+	}
+	expireAt := int64(binary.BigEndian.Uint64(data))
+	if expireAt > 0 && f.now().UnixNano() >= expireAt {
+		_ = os.Remove(f.path(key))
+		return 0, nil, ErrCacheMiss
+	}
+	return expireAt, data[8:], nil
+}
+
+func (f *FileBackend) Scan(ctx context.Context, fn func(key string, val []byte, ttl time.Duration) error) error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		name, ok := strings.CutPrefix(e.Name(), filePrefix)
+		if !ok {
+			continue
+		}
+		key, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+		expireAt, val, err := f.read(key)
+		if errors.Is(err, ErrCacheMiss) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var ttl time.Duration
+		if expireAt > 0 {
+			ttl = time.Duration(expireAt - f.now().UnixNano())
+			if ttl <= 0 {
+				continue
+			}
+		}
+		if err = fn(key, val, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileBackend) Set(_ context.Context, key string, val []byte, ttl time.Duration) error {
+	var expireAt int64
+	if ttl > 0 {
+		expireAt = f.now().Add(ttl).UnixNano()
+	}
+	data := make([]byte, 8, 8+len(val))
+	binary.BigEndian.PutUint64(data, uint64(expireAt))
+	data = append(data, val...)
+	// 先写临时文件再改名，读的时候不会读到写了一半的数据
+	tmp, err := os.CreateTemp(f.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(key))
+}
+
+func (f *FileBackend) Delete(_ context.Context, key string) error {
+	err := os.Remove(f.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// path key 里面可能有 / 之类的字符，转义之后加上前缀作为文件名，避免 .. 和临时文件
+func (f *FileBackend) path(key string) string {
+	return filepath.Join(f.dir, filePrefix+url.PathEscape(key))
+}
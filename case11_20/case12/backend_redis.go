@@ -0,0 +1,77 @@
+package case12
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"interview-cases/internal/redisx"
+)
+
+// RedisBackend 用 redis 做节点的缓存，prefix 用来区分共用同一个 redis 的不同节点
+type RedisBackend struct {
+	client redis.Cmdable
+	prefix string
+}
+
+// NewRedisBackend prefix 后面会补上 : ，不然 node1 的前缀会匹配到 node10 的 key
+func NewRedisBackend(client redis.Cmdable, prefix string) *RedisBackend {
+	if !strings.HasSuffix(prefix, ":") {
+		prefix += ":"
+	}
+	return &RedisBackend{client: client, prefix: prefix}
+}
+
+func (r *RedisBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := r.client.Get(ctx, r.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrCacheMiss
+	}
+	return val, err
+}
+
+func (r *RedisBackend) Set(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		// redis 里面 0 表示永不过期
+		ttl = 0
+	}
+	return r.client.Set(ctx, r.prefix+key, val, ttl).Err()
+}
+
+func (r *RedisBackend) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.prefix+key).Err()
+}
+
+func (r *RedisBackend) Scan(ctx context.Context, fn func(key string, val []byte, ttl time.Duration) error) error {
+	iter := r.client.Scan(ctx, 0, redisx.EscapeGlob(r.prefix)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		if !strings.HasPrefix(key, r.prefix) {
+			continue
+		}
+		var (
+			get *redis.StringCmd
+			ttl *redis.DurationCmd
+		)
+		_, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			get = p.Get(ctx, key)
+			ttl = p.PTTL(ctx, key)
+			return nil
+		})
+		if errors.Is(err, redis.Nil) {
+			// 遍历的过程中过期或者被删除了
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		val, _ := get.Bytes()
+		// 没有过期时间的时候 PTTL 返回 -1
+		if err = fn(key[len(r.prefix):], val, max(ttl.Val(), 0)); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
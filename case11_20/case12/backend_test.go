@@ -0,0 +1,176 @@
+package case12
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"interview-cases/test"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheBackend(t *testing.T) {
+	testCases := []struct {
+		name string
+		// advance 让缓存的时间往前走，redis 只能真的等
+		newBackend func(t *testing.T) (b CacheBackend, advance func(d time.Duration))
+	}{
+		{
+			name: "LRU",
+			newBackend: func(t *testing.T) (CacheBackend, func(d time.Duration)) {
+				b := NewLRUBackend(10)
+				now := time.Now()
+				b.now = func() time.Time { return now }
+				return b, func(d time.Duration) { now = now.Add(d) }
+			},
+		},
+		{
+			name: "文件",
+			newBackend: func(t *testing.T) (CacheBackend, func(d time.Duration)) {
+				b, err := NewFileBackend(t.TempDir() + "/cache")
+				require.NoError(t, err)
+				now := time.Now()
+				b.now = func() time.Time { return now }
+				return b, func(d time.Duration) { now = now.Add(d) }
+			},
+		},
+		{
+			name: "redis",
+			newBackend: func(t *testing.T) (CacheBackend, func(d time.Duration)) {
+				client := test.InitRedis()
+				if err := client.Ping(context.Background()).Err(); err != nil {
+					t.Skip("redis 不可用", err)
+				}
+				return NewRedisBackend(client, "case12:"+t.Name()+":"), time.Sleep
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			b, advance := tc.newBackend(t)
+
+			_, err := b.Get(ctx, "user/1")
+			assert.Equal(t, ErrCacheMiss, err)
+
+			require.NoError(t, b.Set(ctx, "user/1", []byte("tom"), 0))
+			require.NoError(t, b.Set(ctx, "user/2", []byte("jerry"), time.Second))
+			val, err := b.Get(ctx, "user/1")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("tom"), val)
+			val, err = b.Get(ctx, "user/2")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("jerry"), val)
+
+			// 过期之后读不到，没有过期时间的一直都在
+			advance(1100 * time.Millisecond)
+			_, err = b.Get(ctx, "user/2")
+			assert.Equal(t, ErrCacheMiss, err)
+			val, err = b.Get(ctx, "user/1")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("tom"), val)
+
+			// 覆盖
+			require.NoError(t, b.Set(ctx, "user/1", []byte("tom2"), 0))
+			val, err = b.Get(ctx, "user/1")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("tom2"), val)
+
+			require.NoError(t, b.Delete(ctx, "user/1"))
+			_, err = b.Get(ctx, "user/1")
+			assert.Equal(t, ErrCacheMiss, err)
+			// 删除不存在的 key 不报错
+			require.NoError(t, b.Delete(ctx, "user/1"))
+
+			// 遍历拿到剩余的过期时间
+			require.NoError(t, b.Set(ctx, "user/3", []byte("spike"), 0))
+			require.NoError(t, b.Set(ctx, "user/4", []byte("tyke"), time.Minute))
+			vals := make(map[string]string)
+			ttls := make(map[string]time.Duration)
+			err = b.(ScannableBackend).Scan(ctx, func(key string, val []byte, ttl time.Duration) error {
+				vals[key] = string(val)
+				ttls[key] = ttl
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{"user/3": "spike", "user/4": "tyke"}, vals)
+			assert.Equal(t, time.Duration(0), ttls["user/3"])
+			assert.InDelta(t, time.Minute, ttls["user/4"], float64(time.Second))
+			require.NoError(t, b.Delete(ctx, "user/3"))
+			require.NoError(t, b.Delete(ctx, "user/4"))
+		})
+	}
+}
+
+// TestRedisBackend_Prefix 共用一个 redis 的节点，遍历的时候不能拿到别的节点的 key
+func TestRedisBackend_Prefix(t *testing.T) {
+	client := test.InitRedis()
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skip("redis 不可用", err)
+	}
+	prefix := "case12:" + t.Name() + ":"
+	node1 := NewRedisBackend(client, prefix+"node1")
+	node10 := NewRedisBackend(client, prefix+"node10")
+	// 前缀里面的通配符要转义
+	glob := NewRedisBackend(client, prefix+"node*")
+	require.NoError(t, node1.Set(ctx, "user/1", []byte("tom"), 0))
+	require.NoError(t, node10.Set(ctx, "user/10", []byte("jerry"), 0))
+	defer func() {
+		_ = node1.Delete(ctx, "user/1")
+		_ = node10.Delete(ctx, "user/10")
+	}()
+
+	scan := func(b *RedisBackend) map[string]string {
+		res := make(map[string]string)
+		err := b.Scan(ctx, func(key string, val []byte, ttl time.Duration) error {
+			res[key] = string(val)
+			return nil
+		})
+		require.NoError(t, err)
+		return res
+	}
+	assert.Equal(t, map[string]string{"user/1": "tom"}, scan(node1))
+	assert.Equal(t, map[string]string{"user/10": "jerry"}, scan(node10))
+	assert.Empty(t, scan(glob))
+}
+
+func TestLRUBackend_Evict(t *testing.T) {
+	ctx := context.Background()
+	b := NewLRUBackend(2)
+	require.NoError(t, b.Set(ctx, "a", []byte("a"), 0))
+	require.NoError(t, b.Set(ctx, "b", []byte("b"), 0))
+	// 访问 a 之后，最久没有访问的是 b
+	_, err := b.Get(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, b.Set(ctx, "c", []byte("c"), 0))
+
+	_, err = b.Get(ctx, "b")
+	assert.Equal(t, ErrCacheMiss, err)
+	_, err = b.Get(ctx, "a")
+	assert.NoError(t, err)
+	_, err = b.Get(ctx, "c")
+	assert.NoError(t, err)
+}
+
+func TestNode_GetCache(t *testing.T) {
+	ctx := context.Background()
+	fb, err := NewFileBackend(t.TempDir())
+	require.NoError(t, err)
+	n := NewNodeWithCache("a", "a_address", 1, fb)
+	c, err := n.GetCache(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "节点a缓存", c)
+	val, err := fb.Get(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("节点a缓存"), val)
+
+	// 没有设置缓存的节点使用内存缓存
+	n = &Node{name: "b", address: "b_address"}
+	assert.IsType(t, &LRUBackend{}, n.Cache())
+	c, err = n.GetCache(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "节点b缓存", c)
+}
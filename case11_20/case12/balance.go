@@ -0,0 +1,77 @@
+package case12
+
+import (
+	"math"
+	"sort"
+)
+
+// BalanceOptions 控制 Balance 的迁移成本
+type BalanceOptions struct {
+	// MoveCost 迁移一个槽的成本，用请求数衡量。
+	// 只有能让负载偏差至少减少 MoveCost 的迁移才会执行，负载的小幅波动不会引起大量迁移
+	MoveCost float64
+	// MaxMovedSlots 一次 Balance 最多迁移多少个槽，0 表示不限制。
+	// 剩下的迁移留给下一次 Balance，这样 Balance 可以频繁地、渐进地执行
+	MaxMovedSlots int
+}
+
+// SetBalanceOptions 设置 Balance 的迁移成本
+func (h *HashRing) SetBalanceOptions(opts BalanceOptions) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.balanceOpts = opts
+}
+
+// selectMoves 从当前路由表到理想划分的所有迁移里面，挑出值得执行的迁移。
+// 负载偏差用每个节点的请求数和按照权重计算出来的目标值之差的绝对值之和衡量，
+// 优先迁移请求数多的槽，一个迁移只有在偏差减少量超过 MoveCost 的时候才执行。
+// 一轮下来可能有些迁移要等别的迁移执行之后才有收益，所以一直重复到没有迁移可以执行
+func (h *HashRing) selectMoves(cur, target []*Node, requestNumOfSlot []int) map[int]*Node {
+	total := 0
+	loads := make(map[*Node]float64, h.nodeNum)
+	for slot, n := range cur {
+		loads[n] += float64(requestNumOfSlot[slot])
+		total += requestNumOfSlot[slot]
+	}
+	// 已经被删除，但是还持有槽的节点不在 targets 里面，目标负载是 0
+	targets := h.targetLoads(total)
+	deviation := func(n *Node, load float64) float64 {
+		return math.Abs(load - targets[n])
+	}
+
+	candidates := make([]int, 0, len(cur))
+	for slot := range cur {
+		if cur[slot] != target[slot] {
+			candidates = append(candidates, slot)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return requestNumOfSlot[candidates[i]] > requestNumOfSlot[candidates[j]]
+	})
+
+	moves := make(map[int]*Node)
+	maxMoved := h.balanceOpts.MaxMovedSlots
+	for applied := true; applied; {
+		applied = false
+		for _, slot := range candidates {
+			if maxMoved > 0 && len(moves) >= maxMoved {
+				return moves
+			}
+			if _, ok := moves[slot]; ok {
+				continue
+			}
+			from, to := cur[slot], target[slot]
+			w := float64(requestNumOfSlot[slot])
+			improve := deviation(from, loads[from]) + deviation(to, loads[to]) -
+				deviation(from, loads[from]-w) - deviation(to, loads[to]+w)
+			if improve <= 0 || improve < h.balanceOpts.MoveCost {
+				continue
+			}
+			loads[from] -= w
+			loads[to] += w
+			moves[slot] = to
+			applied = true
+		}
+	}
+	return moves
+}
@@ -0,0 +1,106 @@
+package case12
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashRing_BalanceOptions(t *testing.T) {
+	requestNums := []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+	testCases := []struct {
+		name string
+		opts BalanceOptions
+		// 每个元素是一次 Balance 之后迁移的槽
+		wantMoved [][]SlotRange
+	}{
+		{
+			name: "不限制",
+			wantMoved: [][]SlotRange{
+				{{Start: 4, End: 6}, {Start: 6, End: 8}},
+				nil,
+			},
+		},
+		{
+			name: "每次最多迁移一个槽，分多次完成",
+			opts: BalanceOptions{MaxMovedSlots: 1},
+			wantMoved: [][]SlotRange{
+				{{Start: 7, End: 8}},
+				{{Start: 5, End: 6}},
+				{{Start: 6, End: 7}},
+				{{Start: 4, End: 5}},
+				nil,
+			},
+		},
+		{
+			name: "收益不够的迁移不执行",
+			opts: BalanceOptions{MoveCost: 50},
+			wantMoved: [][]SlotRange{
+				{{Start: 5, End: 6}, {Start: 7, End: 8}},
+				nil,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, _ := newTestRing(10, "a", "b", "c")
+			h.SetBalanceOptions(tc.opts)
+			for _, want := range tc.wantMoved {
+				h.SetRequestNumOfSlot(requestNums)
+				plan := h.Balance()
+				var moved []SlotRange
+				for _, m := range plan.Migrations {
+					moved = append(moved, m.SlotRange)
+				}
+				assert.Equal(t, want, moved)
+			}
+		})
+	}
+}
+
+func TestHashRing_BalanceSmallShift(t *testing.T) {
+	h, nodes := newTestRing(12, "a", "b", "c")
+	// 负载基本均匀，只有一个槽稍微多一点，不应该有任何迁移
+	h.SetRequestNumOfSlot([]int{12, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10})
+	plan := h.Balance()
+	assert.Equal(t, 0, plan.MovedSlots())
+	for slot := 0; slot < 12; slot++ {
+		assert.Equal(t, nodes[slot/4], h.GetNode(slot))
+	}
+}
+
+func TestHashRing_Weight(t *testing.T) {
+	a := NewNode("a", "a_address", 1)
+	b := NewNode("b", "b_address", 3)
+	h := NewHashRing([]*Node{a, b}, 8, func(req any) int {
+		return req.(int) % 8
+	})
+	// 初始化的时候就按照权重分配槽
+	assert.Equal(t, map[*Node]int{a: 2, b: 6}, slotCounts(h))
+
+	// 运行时调整权重，Balance 之后按照新的权重分配负载
+	err := h.SetNodeWeight("a", 3)
+	assert.NoError(t, err)
+	err = h.SetNodeWeight("c", 3)
+	assert.Equal(t, ErrNodeNotFound, err)
+	h.SetRequestNumOfSlot([]int{10, 10, 10, 10, 10, 10, 10, 10})
+	h.Balance()
+	assert.Equal(t, map[*Node]int{a: 4, b: 4}, slotCounts(h))
+
+	// 新节点的权重和其它节点加起来一样，分到一半的槽
+	c := NewNode("c", "c_address", 6)
+	plan, err := h.AddNode(c)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, plan.MovedSlots())
+	err = h.ApplyPlan(plan)
+	assert.NoError(t, err)
+	assert.Equal(t, map[*Node]int{a: 2, b: 2, c: 4}, slotCounts(h))
+}
+
+func slotCounts(h *HashRing) map[*Node]int {
+	res := make(map[*Node]int)
+	for _, n := range h.table.Load().slotOfNodes {
+		res[n]++
+	}
+	return res
+}
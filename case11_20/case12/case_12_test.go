@@ -1,8 +1,10 @@
 package case12
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"sync"
 	"testing"
 )
 
@@ -61,15 +63,71 @@ func TestHashRing_Balance(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			h := tc.before()
 			n := h.GetNode(tc.uid)
-			_, err := n.GetCache(tc.uid)
+			_, err := n.GetCache(context.Background(), tc.uid)
 			require.NoError(t, err)
 
 			h.Balance()
 
 			n = h.GetNode(tc.uid)
-			c, err := n.GetCache(tc.uid)
+			c, err := n.GetCache(context.Background(), tc.uid)
 			require.NoError(t, err)
 			assert.Equal(t, tc.wantRes, c)
 		})
 	}
 }
+
+// TestHashRing_Concurrent 并发调用 GetNode 和 Balance，需要使用 -race 运行
+func TestHashRing_Concurrent(t *testing.T) {
+	nodes := []*Node{
+		{name: "a", address: "a_address"},
+		{name: "b", address: "b_address"},
+		{name: "c", address: "c_address"},
+	}
+	h := NewHashRing(nodes, 128, func(req any) int {
+		return req.(int) % 128
+	})
+
+	const (
+		goroutines = 8
+		requests   = 2000
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				// 让前面的槽请求多一点
+				n := h.GetNode((i * j) % (j%128 + 1))
+				assert.NotNil(t, n)
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			h.Balance()
+		}
+	}()
+	wg.Wait()
+	<-done
+
+	// 不调用 Balance 的时候，计数一个都不会少
+	h.SetRequestNumOfSlot(make([]int, 128))
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				h.GetNode(j)
+			}
+		}()
+	}
+	wg.Wait()
+	total := 0
+	for _, cnt := range h.stats.Load().snapshot() {
+		total += cnt
+	}
+	assert.Equal(t, goroutines*requests, total)
+}
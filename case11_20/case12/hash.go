@@ -3,145 +3,268 @@ package case12
 import (
 	"fmt"
 	"github.com/ecodeclub/ekit/slice"
-	"math"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const DefaultHashRingSlotNum = 1024
 
 type HashCodeFunc func(req any) int
 
+// routeTable 槽到节点的映射，构建好之后就是只读的，Balance 的时候整体替换
+type routeTable struct {
+	// epoch 本地路由表的版本，每次变化都加一，包括 ApplySnapshot。只在本进程里面有意义，
+	// 和别的进程比较用 HashRing.storeEpoch
+	epoch       uint64
+	slotOfNodes []*Node
+	// fallback 正在迁移的槽的旧节点，新节点上读不到数据的时候可以回退到旧节点读。
+	// 没有迁移的时候为 nil，迁移过程中不能 Balance
+	fallback []*Node
+	// replicas 每个槽的副本，由 storeTable 根据节点列表计算。没有设置副本的时候为 nil
+	replicas [][]*Node
+}
+
+// clone 复制出下一个版本的路由表
+func (t *routeTable) clone() *routeTable {
+	res := &routeTable{
+		epoch:       t.epoch + 1,
+		slotOfNodes: append([]*Node(nil), t.slotOfNodes...),
+	}
+	if t.fallback != nil {
+		res.fallback = append([]*Node(nil), t.fallback...)
+	} else {
+		res.fallback = make([]*Node, len(t.slotOfNodes))
+	}
+	return res
+}
+
 type HashRing struct {
-	nodes            []*Node
-	slotOfNodes      []*Node
-	requestNumOfSlot []int
-	slotNum          int
-	nodeNum          int
-	lock             sync.RWMutex
-	hashCodeFunc     HashCodeFunc
+	nodes []*Node
+	// table 当前的路由表，GetNode 直接读，不需要加锁
+	table atomic.Pointer[routeTable]
+	// stats 上一次折叠之后每个槽的请求数，折叠到 loads 的时候换成新的
+	stats atomic.Pointer[slotStats]
+	// loads 每个槽随时间衰减的负载，Balance 根据它重新分配槽
+	loads   *slotLoads
+	now     func() time.Time
+	slotNum int
+	nodeNum int
+	// reserved AddNode 和 RemoveNode 返回的计划里面还没有开始迁移的槽，
+	// 有预留的槽的时候也不能 Balance，不然计划会和新的路由表冲突
+	reserved    []bool
+	reservedNum int
+	// replicaNum 每个槽除了主节点之外的副本数量
+	replicaNum  int
+	balanceOpts BalanceOptions
+	// history 最近的 Balance 记录，见 BalanceHistory
+	history []BalanceRecord
+	// lock 保证同一时刻只有一个 Balance 或者节点变更在执行
+	lock         sync.Mutex
+	hashCodeFunc HashCodeFunc
+	// nodeFactory ApplySnapshot 创建新节点用，为 nil 的时候用 NewNode
+	nodeFactory func(name, address string, weight int) *Node
+	// redirected Redirect 创建的节点，同一个节点只创建一次
+	redirected map[string]*Node
+	// storeEpoch 最后一次应用或者发布的路由表在 RouteStore 里面的版本。
+	// 每个进程本地的 epoch 各自递增，没有可比性，ApplySnapshot 和 MovedError 只用它。
+	// 在 h.lock 里面修改，CheckOwner 不加锁读
+	storeEpoch atomic.Uint64
 }
 
 func NewHashRing(nodes []*Node, slotNum int, hashCodeFunc HashCodeFunc) *HashRing {
-	cnt := len(nodes)
-	avg := slotNum / cnt
+	// 按照权重切分成连续的几段
+	weights := totalWeight(nodes)
 	ns := make([]*Node, 0, slotNum)
-	k := 0
-	total := 0
-	for i := 0; i < cnt; i++ {
-		if i == cnt-1 {
-			total = slotNum
-		} else {
-			total += avg
-		}
-		for ; k <= total; k++ {
-			ns = append(ns, nodes[i])
+	cum := 0
+	for _, n := range nodes {
+		cum += n.Weight()
+		end := slotNum * cum / weights
+		for len(ns) < end {
+			ns = append(ns, n)
 		}
-
 	}
-	return &HashRing{
-		nodes:            nodes,
-		slotOfNodes:      ns,
-		requestNumOfSlot: make([]int, slotNum),
-		slotNum:          slotNum,
-		nodeNum:          len(nodes),
-		hashCodeFunc:     hashCodeFunc,
+	h := &HashRing{
+		nodes:        nodes,
+		slotNum:      slotNum,
+		nodeNum:      len(nodes),
+		hashCodeFunc: hashCodeFunc,
+		now:          time.Now,
 	}
+	h.loads = newSlotLoads(slotNum, DefaultLoadHalfLife, h.now())
+	h.storeTable(&routeTable{epoch: 1, slotOfNodes: ns})
+	h.stats.Store(newSlotStats(slotNum))
+	return h
 }
 
+// GetNode 返回负责 uid 的节点，hashCodeFunc 返回的槽超出范围的时候 panic
 func (h *HashRing) GetNode(uid int) *Node {
-	sKey := h.hashCodeFunc(uid)
+	node, _ := h.GetNodeWithFallback(uid)
+	return node
+}
+
+// GetNodeWithFallback 返回负责 uid 的节点，如果 uid 所在的槽正在迁移，
+// fallback 是迁移前的节点，在新节点上读不到数据的时候可以去 fallback 上读
+func (h *HashRing) GetNodeWithFallback(uid int) (node *Node, fallback *Node) {
+	sKey, err := h.slotOf(uid)
+	if err != nil {
+		panic(err)
+	}
+	return h.route(sKey)
+}
+
+// GetNodeByKey 返回负责 key 的节点，hashCodeFunc 需要支持 string 类型的 key，见 KeyHashCodeFunc
+func (h *HashRing) GetNodeByKey(key string) (*Node, error) {
+	node, _, err := h.GetNodeByKeyWithFallback(key)
+	return node, err
+}
+
+// GetNodeByKeyWithFallback 和 GetNodeWithFallback 一样，key 所在的槽正在迁移的时候 fallback 是旧节点
+func (h *HashRing) GetNodeByKeyWithFallback(key string) (node *Node, fallback *Node, err error) {
+	sKey, err := h.slotOf(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	node, fallback = h.route(sKey)
+	return node, fallback, nil
+}
+
+func (h *HashRing) slotOf(req any) (int, error) {
+	sKey := h.hashCodeFunc(req)
+	if sKey < 0 || sKey >= h.slotNum {
+		return 0, fmt.Errorf("%w: %d 不在 [0, %d) 里面", ErrSlotOutOfRange, sKey, h.slotNum)
+	}
+	return sKey, nil
+}
+
+func (h *HashRing) route(sKey int) (node *Node, fallback *Node) {
 	h.countSlotRequest(sKey)
-	return h.slotOfNodes[sKey]
+	t := h.table.Load()
+	if t.fallback != nil {
+		fallback = t.fallback[sKey]
+	}
+	return t.primary(sKey), fallback
 }
 
 func (h *HashRing) countSlotRequest(sKey int) {
-	h.requestNumOfSlot[sKey]++
+	h.stats.Load().inc(sKey)
 }
 
-func (h *HashRing) Balance() {
+// Balance 根据每个槽衰减之后的负载重新分配槽，返回实际迁移的槽。
+// 先计算出理想的划分，然后只执行那些能够显著减少负载偏差的迁移，见 BalanceOptions
+func (h *HashRing) Balance() *MigrationPlan {
 	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.balance(h.foldLoads(), true)
+}
 
-	// 计算前缀和，方便快速计算子数组的和
-	prefixSum := h.prefixSums()
-
-	totalRequest := slice.Sum[int](h.requestNumOfSlot)
-	avgRequest := totalRequest / h.nodeNum
-
-	//fmt.Printf("总请求数为：%d，平均请求数为：%d\n", totalRequest, avgRequest)
-
-	// dp[i][j] 表示前 i 个元素分成 j 段，最小的偏差值
-	dp := make([][]int, h.slotNum+1)
-	cuts := make([][]int, h.slotNum+1)
-	for i := range dp {
-		dp[i] = make([]int, h.nodeNum+1)
-		cuts[i] = make([]int, h.nodeNum+1)
-		for j := range dp[i] {
-			if j == 0 {
-				dp[i][j] = 0 // 初始状态
-			} else {
-				dp[i][j] = math.MaxInt64 // 其他状态初始化为最大值
-			}
-		}
+// PlanBalance 和 Balance 一样计算迁移计划，但是不切换路由。
+// 调用方用 Migrator 一边搬迁数据一边切换路由，新节点不会因为缓存是空的被打穿
+func (h *HashRing) PlanBalance() *MigrationPlan {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.balance(h.foldLoads(), false)
+}
+
+// balance apply 为 true 的时候立刻切换路由。调用方必须持有 h.lock
+func (h *HashRing) balance(requestNumOfSlot []int, apply bool) *MigrationPlan {
+	if h.table.Load().fallback != nil || h.reservedNum > 0 {
+		// 迁移没有完成，重新划分会和迁移计划冲突
+		return &MigrationPlan{}
 	}
 
-	// 动态规划计算
-	for j := 2; j <= h.nodeNum; j++ {
-		for i := j; i <= h.slotNum; i++ {
-			for k := j - 1; k < i; k++ {
-				sum := prefixSum[i] - prefixSum[k] // 计算子数组和
-				diff := int(math.Abs(float64(avgRequest - sum)))
-				if dp[k][j-1]+diff < dp[i][j] {
-					dp[i][j] = dp[k][j-1] + diff
-					cuts[i][j] = k
-				}
-			}
-			//fmt.Printf("dp[%d][%d] = %d\n", i, j, dp[i][j])     // 打印dp表更新
-			//fmt.Printf("cuts[%d][%d] = %d\n", i, j, cuts[i][j]) // 打印cuts表更新
-		}
+	cur := h.table.Load().slotOfNodes
+	target := h.partition(requestNumOfSlot)
+	moves := h.selectMoves(cur, target, requestNumOfSlot)
+	ns := append([]*Node(nil), cur...)
+	for slot, n := range moves {
+		ns[slot] = n
+	}
+	plan := buildPlan(cur, moves)
+	if apply && len(moves) > 0 {
+		nt := h.table.Load().clone()
+		nt.fallback = nil
+		nt.slotOfNodes = ns
+		h.storeTable(nt)
 	}
+	h.recordBalance(BalanceRecord{
+		Time:            h.now(),
+		Epoch:           h.table.Load().epoch,
+		Applied:         apply,
+		ImbalanceBefore: h.imbalanceOf(cur, requestNumOfSlot),
+		ImbalanceAfter:  h.imbalanceOf(ns, requestNumOfSlot),
+		MovedSlots:      plan.MovedSlots(),
+		Migrations:      plan.Migrations,
+	})
+	return plan
+}
 
-	// 回溯找到切割点
-	bestSplitKey := make([]int, h.nodeNum)
-	bestSplit := make([][]int, h.nodeNum)
-	idx := h.slotNum
-	for j := h.nodeNum; j > 0; j-- {
-		prevIdx := cuts[idx][j]
-		bestSplitKey[j-1] = idx
-		bestSplit[j-1] = h.requestNumOfSlot[prevIdx:idx]
-		//fmt.Printf("回溯切割点: %d, 子数组: %v\n", prevIdx, bestSplit[j-1]) // 打印切割点
-		idx = prevIdx
+// partition 把槽按照节点的顺序切分成连续的几段，每个节点一段，
+// 让每个节点的 请求数/权重 尽量均衡
+func (h *HashRing) partition(requestNumOfSlot []int) []*Node {
+	weights := make([]int, h.nodeNum)
+	for i, n := range h.nodes {
+		weights[i] = n.Weight()
 	}
+	ends := partitionByLoad(requestNumOfSlot, weights)
 
-	// 打印最终结果
-	for i, subarray := range bestSplit {
-		fmt.Printf("子数组 %d: %v, 和: %d\n", i+1, subarray, slice.Sum[int](subarray))
+	start := 0
+	for i, end := range ends {
+		slog.Debug("槽的理想划分", slog.String("node", h.nodes[i].name),
+			slog.Int("start", start), slog.Int("end", end),
+			slog.Int("requests", slice.Sum[int](requestNumOfSlot[start:end])))
+		start = end
 	}
-	//fmt.Printf("最小偏差总和: %d\n", dp[h.slotNum][h.nodeNum])
 
 	// 初始化hash槽
 	ns := make([]*Node, 0, h.slotNum)
 	key := 0
 	for k, v := range h.nodes {
-		for ; key < bestSplitKey[k]; key++ {
+		for ; key < ends[k]; key++ {
 			ns = append(ns, v)
 		}
 	}
+	return ns
+}
 
-	h.slotOfNodes = ns
-	h.requestNumOfSlot = make([]int, h.slotNum)
-	h.lock.Unlock()
+// targetLoads 每个节点的目标请求数，和权重成正比
+func (h *HashRing) targetLoads(total int) map[*Node]float64 {
+	weights := totalWeight(h.nodes)
+	res := make(map[*Node]float64, len(h.nodes))
+	for _, n := range h.nodes {
+		res[n] = float64(total) * float64(n.Weight()) / float64(weights)
+	}
+	return res
 }
 
-// 计算数组的部分和
-func (h *HashRing) prefixSums() []int {
-	prefixSum := make([]int, h.slotNum+1)
-	for i := 1; i <= h.slotNum; i++ {
-		prefixSum[i] = prefixSum[i-1] + h.requestNumOfSlot[i-1]
+// SetNodeWeight 运行时修改节点的权重，下一次 Balance 的时候生效
+func (h *HashRing) SetNodeWeight(name string, weight int) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	idx := h.findNode(name)
+	if idx < 0 {
+		return ErrNodeNotFound
 	}
-	return prefixSum
+	h.nodes[idx].weight.Store(int64(weight))
+	return nil
 }
 
-// 方便测试
+func totalWeight(nodes []*Node) int {
+	res := 0
+	for _, n := range nodes {
+		res += n.Weight()
+	}
+	return res
+}
+
+// 方便测试，直接设置每个槽的负载
 func (h *HashRing) SetRequestNumOfSlot(requestNums []int) {
-	h.requestNumOfSlot = requestNums
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.stats.Store(newSlotStats(h.slotNum))
+	h.loads = newSlotLoads(h.slotNum, h.loads.halfLife, h.now())
+	for slot, num := range requestNums {
+		h.loads.loads[slot] = float64(num)
+	}
 }
@@ -0,0 +1,46 @@
+package case12
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// MaxBalanceHistory 最多保留多少次 Balance 的记录
+const MaxBalanceHistory = 32
+
+// BalanceRecord 一次 Balance 或者 PlanBalance 的结果
+type BalanceRecord struct {
+	Time  time.Time `json:"time"`
+	Epoch uint64    `json:"epoch"`
+	// Applied PlanBalance 的时候是 false，路由没有切换
+	Applied bool `json:"applied"`
+	// ImbalanceBefore 和 ImbalanceAfter 迁移前后的不均衡度，见 Imbalance
+	ImbalanceBefore float64     `json:"imbalance_before"`
+	ImbalanceAfter  float64     `json:"imbalance_after"`
+	MovedSlots      int         `json:"moved_slots"`
+	Migrations      []Migration `json:"migrations"`
+}
+
+// recordBalance 调用方必须持有 h.lock
+func (h *HashRing) recordBalance(r BalanceRecord) {
+	level := slog.LevelInfo
+	if r.MovedSlots == 0 {
+		// 没有迁移的 Balance 很频繁，比如 AutoBalance
+		level = slog.LevelDebug
+	}
+	slog.Log(context.Background(), level, "Balance", slog.Uint64("epoch", r.Epoch), slog.Bool("applied", r.Applied),
+		slog.Float64("imbalance_before", r.ImbalanceBefore), slog.Float64("imbalance_after", r.ImbalanceAfter),
+		slog.Int("moved_slots", r.MovedSlots))
+	h.history = append(h.history, r)
+	if len(h.history) > MaxBalanceHistory {
+		h.history = h.history[len(h.history)-MaxBalanceHistory:]
+	}
+}
+
+// BalanceHistory 最近的 Balance 记录，从旧到新排列
+func (h *HashRing) BalanceHistory() []BalanceRecord {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]BalanceRecord(nil), h.history...)
+}
@@ -0,0 +1,44 @@
+package case12
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashRing_BalanceHistory(t *testing.T) {
+	h, _ := newTestRing(10, "a", "b", "c")
+	now := time.UnixMilli(1000).UTC()
+	h.now = func() time.Time {
+		return now
+	}
+	h.SetRequestNumOfSlot([]int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100})
+	h.PlanBalance()
+	h.Balance()
+	h.Balance()
+
+	history := h.BalanceHistory()
+	require.Len(t, history, 3)
+	assert.False(t, history[0].Applied)
+	assert.Equal(t, uint64(1), history[0].Epoch)
+	assert.Equal(t, history[0].Migrations, history[1].Migrations)
+	assert.Equal(t, uint64(2), history[1].Epoch)
+	assert.Greater(t, history[1].ImbalanceBefore, history[1].ImbalanceAfter)
+	// 已经均衡了，第二次 Balance 没有迁移
+	assert.Equal(t, 0, history[2].MovedSlots)
+	assert.Equal(t, history[1].ImbalanceAfter, history[2].ImbalanceBefore)
+
+	data, err := json.Marshal(history[1])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"time":"1970-01-01T00:00:01Z","epoch":2,"applied":true,
+		"imbalance_before":1.8545454545454545,"imbalance_after":1.0363636363636364,"moved_slots":4,
+		"migrations":[{"start":4,"end":6,"from":"b","to":"a"},{"start":6,"end":8,"from":"c","to":"b"}]}`, string(data))
+
+	for i := 0; i < MaxBalanceHistory; i++ {
+		h.Balance()
+	}
+	assert.Len(t, h.BalanceHistory(), MaxBalanceHistory)
+}
@@ -0,0 +1,66 @@
+package case12
+
+import (
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// JumpHash Google 的 jump consistent hash，不需要额外的内存，负载非常均匀，但是不支持权重。
+// 节点只能在末尾加入或者删除，删除中间的节点会导致后面的节点全部错位，大量的 key 被重新映射
+type JumpHash struct {
+	lock  sync.RWMutex
+	nodes []*Node
+}
+
+func NewJumpHash(nodes []*Node) *JumpHash {
+	return &JumpHash{nodes: nodes}
+}
+
+func (j *JumpHash) GetNodeByKey(key string) (*Node, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	if len(j.nodes) == 0 {
+		return nil, ErrNoNode
+	}
+	return j.nodes[jump(xxhash.Sum64(HashTag([]byte(key))), len(j.nodes))], nil
+}
+
+func (j *JumpHash) Join(node *Node) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	nodes, err := appendNode(j.nodes, node)
+	if err != nil {
+		return err
+	}
+	j.nodes = nodes
+	return nil
+}
+
+func (j *JumpHash) Leave(name string) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	nodes, err := deleteNode(j.nodes, name)
+	if err != nil {
+		return err
+	}
+	j.nodes = nodes
+	return nil
+}
+
+func (j *JumpHash) Nodes() []*Node {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	return append([]*Node(nil), j.nodes...)
+}
+
+// jump 论文 A Fast, Minimal Memory, Consistent Hash Algorithm 里面的实现
+func jump(key uint64, buckets int) int {
+	b, j := int64(-1), int64(0)
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,208 @@
+package case12
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var ErrInvalidBoundedLoadFactor = errors.New("BoundedLoad 的 factor 必须大于 1")
+
+// DefaultKetamaPoints 权重为 1 的节点在哈希环上的虚拟节点数量，和 libketama 一样是 160
+const DefaultKetamaPoints = 160
+
+// ketamaRing 哈希环，构建好之后就是只读的
+type ketamaRing struct {
+	points []uint32
+	owners []*Node
+}
+
+// newKetamaRing 和 libketama 一样，每个 md5 摘要切成 4 个虚拟节点，虚拟节点的数量和权重成正比
+func newKetamaRing(nodes []*Node, pointsPerWeight int) *ketamaRing {
+	type point struct {
+		hash  uint32
+		owner *Node
+	}
+	ps := make([]point, 0, len(nodes)*pointsPerWeight)
+	for _, n := range nodes {
+		digests := (n.Weight()*pointsPerWeight + 3) / 4
+		for i := 0; i < digests; i++ {
+			sum := md5.Sum([]byte(n.name + "-" + strconv.Itoa(i)))
+			for j := 0; j < 4; j++ {
+				ps = append(ps, point{hash: binary.LittleEndian.Uint32(sum[j*4:]), owner: n})
+			}
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		return ps[i].hash < ps[j].hash
+	})
+	r := &ketamaRing{
+		points: make([]uint32, len(ps)),
+		owners: make([]*Node, len(ps)),
+	}
+	for i, p := range ps {
+		r.points[i] = p.hash
+		r.owners[i] = p.owner
+	}
+	return r
+}
+
+// search 顺时针方向第一个虚拟节点的下标
+func (r *ketamaRing) search(key string) int {
+	sum := md5.Sum(HashTag([]byte(key)))
+	hash := binary.LittleEndian.Uint32(sum[:])
+	idx := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= hash
+	})
+	if idx == len(r.points) {
+		idx = 0
+	}
+	return idx
+}
+
+// Ketama 带虚拟节点的一致性哈希
+type Ketama struct {
+	lock  sync.RWMutex
+	nodes []*Node
+	ring  *ketamaRing
+}
+
+func NewKetama(nodes []*Node) *Ketama {
+	return &Ketama{nodes: nodes, ring: newKetamaRing(nodes, DefaultKetamaPoints)}
+}
+
+func (k *Ketama) GetNodeByKey(key string) (*Node, error) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	if len(k.nodes) == 0 {
+		return nil, ErrNoNode
+	}
+	return k.ring.owners[k.ring.search(key)], nil
+}
+
+func (k *Ketama) Join(node *Node) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	nodes, err := appendNode(k.nodes, node)
+	if err != nil {
+		return err
+	}
+	k.nodes, k.ring = nodes, newKetamaRing(nodes, DefaultKetamaPoints)
+	return nil
+}
+
+func (k *Ketama) Leave(name string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	nodes, err := deleteNode(k.nodes, name)
+	if err != nil {
+		return err
+	}
+	k.nodes, k.ring = nodes, newKetamaRing(nodes, DefaultKetamaPoints)
+	return nil
+}
+
+func (k *Ketama) Nodes() []*Node {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return append([]*Node(nil), k.nodes...)
+}
+
+// BoundedLoad 有界负载的一致性哈希：每个节点最多承担 平均负载 * factor * 权重 个 key，
+// 超过上限之后顺时针找下一个还有余量的节点。
+// 负载是分配到节点上的 key 的数量，同一个 key 重复调用 GetNodeByKey 不会增加负载，
+// 一直落在第一次分配的节点上，直到 Release 或者节点变更之后重新计数。
+// key 不再使用的时候（比如缓存过期或者被删除）要调用 Release，不然分配记录会一直增长
+type BoundedLoad struct {
+	lock   sync.Mutex
+	factor float64
+	nodes  []*Node
+	ring   *ketamaRing
+	loads  map[*Node]int
+	// assigned 已经分配的 key 和节点
+	assigned map[string]*Node
+}
+
+// NewBoundedLoad factor 必须大于 1，越接近 1 负载越均衡，但是越多的 key 会偏离原本的节点。
+// factor 小于等于 1 的时候所有节点都可能达到上限，返回 ErrInvalidBoundedLoadFactor
+func NewBoundedLoad(nodes []*Node, factor float64) (*BoundedLoad, error) {
+	if !(factor > 1) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBoundedLoadFactor, factor)
+	}
+	b := &BoundedLoad{factor: factor}
+	b.reset(nodes)
+	return b, nil
+}
+
+func (b *BoundedLoad) GetNodeByKey(key string) (*Node, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.nodes) == 0 {
+		return nil, ErrNoNode
+	}
+	if n, ok := b.assigned[key]; ok {
+		return n, nil
+	}
+	avg := float64(len(b.assigned)+1) / float64(totalWeight(b.nodes))
+	idx := b.ring.search(key)
+	for i := 0; i < len(b.ring.owners); i++ {
+		n := b.ring.owners[(idx+i)%len(b.ring.owners)]
+		if float64(b.loads[n]) < math.Ceil(avg*b.factor*float64(n.Weight())) {
+			b.loads[n]++
+			b.assigned[key] = n
+			return n, nil
+		}
+	}
+	// factor 大于 1 的时候总有节点没有达到上限，走不到这里
+	return nil, ErrNoNode
+}
+
+// Release key 不再使用，释放它占用的负载。key 没有分配过的时候什么也不做
+func (b *BoundedLoad) Release(key string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if n, ok := b.assigned[key]; ok {
+		delete(b.assigned, key)
+		b.loads[n]--
+	}
+}
+
+func (b *BoundedLoad) Join(node *Node) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	nodes, err := appendNode(b.nodes, node)
+	if err != nil {
+		return err
+	}
+	b.reset(nodes)
+	return nil
+}
+
+func (b *BoundedLoad) Leave(name string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	nodes, err := deleteNode(b.nodes, name)
+	if err != nil {
+		return err
+	}
+	b.reset(nodes)
+	return nil
+}
+
+func (b *BoundedLoad) Nodes() []*Node {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]*Node(nil), b.nodes...)
+}
+
+func (b *BoundedLoad) reset(nodes []*Node) {
+	b.nodes = nodes
+	b.ring = newKetamaRing(nodes, DefaultKetamaPoints)
+	b.loads = make(map[*Node]int, len(nodes))
+	b.assigned = make(map[string]*Node)
+}
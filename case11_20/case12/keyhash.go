@@ -0,0 +1,121 @@
+package case12
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// RedisClusterSlotNum Redis Cluster 的槽数量，配合 CRC16 使用的时候和 Redis Cluster 的槽一致
+const RedisClusterSlotNum = 16384
+
+// ErrSlotOutOfRange HashCodeFunc 返回的槽不在 [0, slotNum) 里面，或者 key 的类型不支持
+var ErrSlotOutOfRange = errors.New("槽超出范围")
+
+// KeyHashFunc 计算 key 的哈希值，槽是 哈希值 % slotNum
+type KeyHashFunc func(key []byte) uint64
+
+// CRC16 Redis Cluster 使用的 CRC16（XMODEM）
+func CRC16(key []byte) uint64 {
+	crc := uint16(0)
+	for _, b := range key {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return uint64(crc)
+}
+
+// XXHash xxhash64
+func XXHash(key []byte) uint64 {
+	return xxhash.Sum64(key)
+}
+
+// Murmur3 murmur3 的 32 位版本，种子是 0
+func Murmur3(key []byte) uint64 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	h := uint32(0)
+	n := len(key) / 4 * 4
+	for i := 0; i < n; i += 4 {
+		k := binary.LittleEndian.Uint32(key[i:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	k := uint32(0)
+	switch len(key) - n {
+	case 3:
+		k ^= uint32(key[n+2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(key[n+1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(key[n])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(len(key))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return uint64(h)
+}
+
+// HashTag 和 Redis Cluster 一样，key 里面有 {tag} 并且 tag 不为空的时候只用 tag 计算槽，
+// 这样 user:{1000}:profile 和 user:{1000}:orders 会落在同一个槽
+func HashTag(key []byte) []byte {
+	start := bytes.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+	end := bytes.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
+
+// KeyHashCodeFunc 用 hash 计算槽的 HashCodeFunc，支持 string、[]byte 和 int 类型的 key，
+// 支持 {tag}。其它类型的 key 返回 -1，GetNodeByKey 会返回 ErrSlotOutOfRange
+func KeyHashCodeFunc(hash KeyHashFunc, slotNum int) HashCodeFunc {
+	return func(req any) int {
+		var key []byte
+		switch k := req.(type) {
+		case string:
+			key = []byte(k)
+		case []byte:
+			key = k
+		case int:
+			key = strconv.AppendInt(nil, int64(k), 10)
+		default:
+			return -1
+		}
+		return int(hash(HashTag(key)) % uint64(slotNum))
+	}
+}
+
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
@@ -0,0 +1,91 @@
+package case12
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyHashFunc(t *testing.T) {
+	testCases := []struct {
+		name string
+		hash KeyHashFunc
+		key  string
+		want uint64
+	}{
+		{name: "CRC16", hash: CRC16, key: "123456789", want: 0x31c3},
+		{name: "CRC16 空 key", hash: CRC16, key: "", want: 0},
+		{name: "murmur3 空 key", hash: Murmur3, key: "", want: 0},
+		{name: "murmur3", hash: Murmur3, key: "hello", want: 0x248bfa47},
+		{name: "murmur3 有尾巴", hash: Murmur3, key: "The quick brown fox jumps over the lazy dog", want: 0x2e4ff723},
+		{name: "xxhash 空 key", hash: XXHash, key: "", want: 0xef46db3751d8e999},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.hash([]byte(tc.key)))
+		})
+	}
+}
+
+func TestHashTag(t *testing.T) {
+	testCases := []struct {
+		key  string
+		want string
+	}{
+		{key: "user:1000", want: "user:1000"},
+		{key: "user:{1000}:profile", want: "1000"},
+		{key: "{user}{1000}", want: "user"},
+		// 和 Redis 一样，{} 里面是空的时候用整个 key
+		{key: "{}user:1000", want: "{}user:1000"},
+		{key: "user{:1000", want: "user{:1000"},
+		{key: "user}:{1000}", want: "1000"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			assert.Equal(t, tc.want, string(HashTag([]byte(tc.key))))
+		})
+	}
+}
+
+func TestHashRing_GetNodeByKey(t *testing.T) {
+	a := NewNode("a", "a_address", 1)
+	b := NewNode("b", "b_address", 1)
+	h := NewHashRing([]*Node{a, b}, RedisClusterSlotNum, KeyHashCodeFunc(CRC16, RedisClusterSlotNum))
+
+	// 槽和 Redis Cluster 的 CLUSTER KEYSLOT 一致
+	slotFunc := KeyHashCodeFunc(CRC16, RedisClusterSlotNum)
+	assert.Equal(t, 12182, slotFunc("foo"))
+	assert.Equal(t, 5061, slotFunc([]byte("bar")))
+
+	n, err := h.GetNodeByKey("foo")
+	require.NoError(t, err)
+	assert.Equal(t, b, n)
+	n, err = h.GetNodeByKey("bar")
+	require.NoError(t, err)
+	assert.Equal(t, a, n)
+
+	// 同一个 tag 的 key 落在同一个节点上
+	n, err = h.GetNodeByKey("{bar}foo")
+	require.NoError(t, err)
+	assert.Equal(t, a, n)
+
+	// int 类型的 key 当作十进制字符串处理
+	assert.Equal(t, slotFunc("1000"), slotFunc(1000))
+	assert.Equal(t, -1, slotFunc(1.5))
+}
+
+func TestHashRing_SlotOutOfRange(t *testing.T) {
+	h := NewHashRing([]*Node{NewNode("a", "a_address", 1)}, 8, func(req any) int {
+		if uid, ok := req.(int); ok {
+			return uid
+		}
+		return -1
+	})
+	_, err := h.GetNodeByKey("foo")
+	assert.ErrorIs(t, err, ErrSlotOutOfRange)
+	assert.PanicsWithError(t, "槽超出范围: 8 不在 [0, 8) 里面", func() {
+		h.GetNode(8)
+	})
+	assert.NotNil(t, h.GetNode(7))
+}
@@ -0,0 +1,154 @@
+package case12
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// DefaultLoadHalfLife 槽负载默认的半衰期
+const DefaultLoadHalfLife = time.Minute
+
+// slotLoads 每个槽随时间衰减的负载。每次折叠的时候把新的请求数加进来，
+// 之前的负载按照经过的时间衰减，每过一个半衰期权重减半。
+// 这样负载和折叠的频率无关，Balance 之后也不会丢掉历史
+type slotLoads struct {
+	halfLife time.Duration
+	last     time.Time
+	loads    []float64
+}
+
+func newSlotLoads(slotNum int, halfLife time.Duration, now time.Time) *slotLoads {
+	return &slotLoads{
+		halfLife: halfLife,
+		last:     now,
+		loads:    make([]float64, slotNum),
+	}
+}
+
+func (l *slotLoads) fold(counts []int, now time.Time) {
+	decay := math.Exp2(-float64(now.Sub(l.last)) / float64(l.halfLife))
+	for slot, cnt := range counts {
+		l.loads[slot] = l.loads[slot]*decay + float64(cnt)
+	}
+	l.last = now
+}
+
+func (l *slotLoads) ints() []int {
+	res := make([]int, len(l.loads))
+	for slot, load := range l.loads {
+		res[slot] = int(math.Round(load))
+	}
+	return res
+}
+
+// foldLoads 把计数器里面的请求数折叠到衰减负载里面，返回每个槽当前的负载。
+// 调用方必须持有 h.lock
+func (h *HashRing) foldLoads() []int {
+	counts := h.stats.Swap(newSlotStats(h.slotNum)).snapshot()
+	h.loads.fold(counts, h.now())
+	return h.loads.ints()
+}
+
+// SetLoadHalfLife 设置槽负载的半衰期，半衰期越短越关注最近的请求
+func (h *HashRing) SetLoadHalfLife(halfLife time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	// 之前的请求按照旧的半衰期衰减
+	h.foldLoads()
+	h.loads.halfLife = halfLife
+}
+
+// SlotLoads 每个槽当前衰减之后的负载
+func (h *HashRing) SlotLoads() []float64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.foldLoads()
+	return append([]float64(nil), h.loads.loads...)
+}
+
+// Imbalance 负载最重的节点的 负载/权重 和所有节点平均值的比值，1 表示完全均衡。没有负载的时候返回 1
+func (h *HashRing) Imbalance() float64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.imbalance(h.foldLoads())
+}
+
+func (h *HashRing) imbalance(requestNumOfSlot []int) float64 {
+	return h.imbalanceOf(h.table.Load().slotOfNodes, requestNumOfSlot)
+}
+
+// imbalanceOf 按照 slots 这个路由计算不均衡度
+func (h *HashRing) imbalanceOf(slots []*Node, requestNumOfSlot []int) float64 {
+	total := 0
+	loads := make(map[*Node]int, h.nodeNum)
+	for slot, n := range slots {
+		loads[n] += requestNumOfSlot[slot]
+		total += requestNumOfSlot[slot]
+	}
+	if total == 0 {
+		return 1
+	}
+	avg := float64(total) / float64(totalWeight(h.nodes))
+	res := 0.0
+	for n, load := range loads {
+		res = math.Max(res, float64(load)/float64(n.Weight())/avg)
+	}
+	return res
+}
+
+// DefaultAutoBalanceInterval AutoBalanceOptions 没有设置 Interval 的时候的检查间隔
+const DefaultAutoBalanceInterval = time.Second * 10
+
+// AutoBalanceOptions 控制后台自动 Balance
+type AutoBalanceOptions struct {
+	// Interval 检查负载的间隔，小于等于 0 的时候用 DefaultAutoBalanceInterval
+	Interval time.Duration
+	// Threshold 不均衡度超过这个值才 Balance，见 Imbalance。例如 1.2 表示最重的节点比平均值多 20%
+	Threshold float64
+	// OnBalance 有槽迁移的时候回调。Apply 为 false 的时候路由还没有切换，
+	// 调用方用 Migrator.Migrate 执行计划，或者 AbortPlan 放弃。为 nil 的时候相当于 Apply 为 true
+	OnBalance func(plan *MigrationPlan)
+	// Apply 为 true 的时候立刻切换路由，不搬迁数据，和 Balance 一样。
+	// 为 false 的时候和 AddNode 一样只生成计划，计划里面的槽在开始迁移之前是预留的
+	Apply bool
+}
+
+// AutoBalance 定时检查负载，不均衡度超过阈值的时候执行 Balance。会一直阻塞到 ctx 被取消
+func (h *HashRing) AutoBalance(ctx context.Context, opts AutoBalanceOptions) {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultAutoBalanceInterval
+	}
+	if opts.OnBalance == nil {
+		// 没有人执行计划
+		opts.Apply = true
+	}
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		plan := h.balanceIfImbalanced(opts.Threshold, opts.Apply)
+		if plan.MovedSlots() > 0 && opts.OnBalance != nil {
+			opts.OnBalance(plan)
+		}
+	}
+}
+
+func (h *HashRing) balanceIfImbalanced(threshold float64, apply bool) *MigrationPlan {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	requestNumOfSlot := h.foldLoads()
+	if h.imbalance(requestNumOfSlot) <= threshold {
+		return &MigrationPlan{}
+	}
+	plan := h.balance(requestNumOfSlot, apply)
+	if !apply {
+		// 计划执行之前不能再 Balance，不然下一次检查的时候会生成冲突的计划
+		h.reserve(plan.Migrations, true)
+	}
+	return plan
+}
@@ -0,0 +1,182 @@
+package case12
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashRing_SlotLoads(t *testing.T) {
+	h, _ := newTestRing(4, "a", "b")
+	now := time.UnixMilli(0)
+	h.now = func() time.Time {
+		return now
+	}
+	h.SetLoadHalfLife(time.Minute)
+	h.SetRequestNumOfSlot([]int{80, 40, 0, 0})
+
+	// 过了一个半衰期，之前的负载减半，新的请求直接加上去
+	now = now.Add(time.Minute)
+	for i := 0; i < 10; i++ {
+		h.GetNode(2)
+	}
+	assert.Equal(t, []float64{40, 20, 10, 0}, h.SlotLoads())
+
+	// Balance 不会清空负载
+	h.Balance()
+	assert.Equal(t, []float64{40, 20, 10, 0}, h.SlotLoads())
+
+	// 再过两个半衰期
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, []float64{10, 5, 2.5, 0}, h.SlotLoads())
+}
+
+func TestHashRing_Imbalance(t *testing.T) {
+	testCases := []struct {
+		name  string
+		loads []int
+		want  float64
+	}{
+		{
+			name:  "没有负载",
+			loads: []int{0, 0, 0, 0},
+			want:  1,
+		},
+		{
+			name:  "完全均衡",
+			loads: []int{10, 10, 10, 10},
+			want:  1,
+		},
+		{
+			name:  "全部落在一个节点上",
+			loads: []int{10, 10, 0, 0},
+			want:  2,
+		},
+		{
+			name:  "比平均值多一半",
+			loads: []int{20, 10, 5, 5},
+			want:  1.5,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, _ := newTestRing(4, "a", "b")
+			h.SetRequestNumOfSlot(tc.loads)
+			assert.InDelta(t, tc.want, h.Imbalance(), 1e-9)
+		})
+	}
+}
+
+func TestHashRing_AutoBalance(t *testing.T) {
+	h, nodes := newTestRing(8, "a", "b")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	plans := make(chan *MigrationPlan, 10)
+	go h.AutoBalance(ctx, AutoBalanceOptions{
+		Interval:  10 * time.Millisecond,
+		Threshold: 1.2,
+		OnBalance: func(plan *MigrationPlan) {
+			plans <- plan
+		},
+		Apply: true,
+	})
+
+	// 没有超过阈值，不会 Balance
+	h.SetRequestNumOfSlot([]int{12, 10, 10, 10, 10, 10, 10, 10})
+	select {
+	case <-plans:
+		t.Fatal("没有超过阈值不应该 Balance")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 请求都落在 a 上面，超过阈值之后自动 Balance
+	h.SetRequestNumOfSlot([]int{10, 10, 10, 10, 0, 0, 0, 0})
+	select {
+	case plan := <-plans:
+		assert.Equal(t, []Migration{
+			{SlotRange: SlotRange{Start: 2, End: 4}, From: nodes[0], To: nodes[1]},
+		}, plan.Migrations)
+	case <-time.After(time.Second):
+		require.FailNow(t, "超过阈值之后没有 Balance")
+	}
+	assert.InDelta(t, 1.0, h.Imbalance(), 1e-9)
+}
+
+// TestHashRing_AutoBalanceMigrate 不立刻切换路由，在 OnBalance 里面用 Migrator 搬数据
+func TestHashRing_AutoBalanceMigrate(t *testing.T) {
+	h := newMigrationRing("a", "b")
+	m := NewMigrator(h)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, m.Set(ctx, fmt.Sprintf("key_%d", i), []byte("val"), 0))
+	}
+	errs := make(chan error, 10)
+	go h.AutoBalance(ctx, AutoBalanceOptions{
+		Interval:  10 * time.Millisecond,
+		Threshold: 1.2,
+		OnBalance: func(plan *MigrationPlan) {
+			// 路由还没有切换
+			assert.False(t, m.completed(plan.Migrations[0]))
+			errs <- m.Migrate(ctx, plan, nil)
+		},
+	})
+
+	// 请求都落在 a 上面
+	slots := h.table.Load().slotOfNodes
+	a := slots[0]
+	loads := make([]int, len(slots))
+	for slot, n := range slots {
+		if n == a {
+			loads[slot] = 10
+		}
+	}
+	h.SetRequestNumOfSlot(loads)
+	select {
+	case err := <-errs:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		require.FailNow(t, "超过阈值之后没有 Balance")
+	}
+
+	// 数据跟着槽搬到了新的节点上，旧节点上没有了
+	moved := 0
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		owner, err := h.GetNodeByKey(key)
+		require.NoError(t, err)
+		for _, n := range h.Nodes() {
+			_, err = n.Cache().Get(ctx, key)
+			if n == owner {
+				assert.NoError(t, err, key)
+			} else {
+				assert.Equal(t, ErrCacheMiss, err, key)
+			}
+		}
+		if owner != a {
+			moved++
+		}
+	}
+	assert.Greater(t, moved, 50)
+}
+
+func TestHashRing_AutoBalanceDefaultInterval(t *testing.T) {
+	h, _ := newTestRing(8, "a", "b")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// 没有设置 Interval 也不会 panic
+		h.AutoBalance(ctx, AutoBalanceOptions{Threshold: 1.2})
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.FailNow(t, "ctx 取消之后 AutoBalance 没有返回")
+	}
+}
@@ -0,0 +1,318 @@
+package case12
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrNodeExists        = errors.New("节点已经存在")
+	ErrNodeNotFound      = errors.New("节点不存在")
+	ErrLastNode          = errors.New("不能删除最后一个节点")
+	ErrMigrationConflict = errors.New("迁移和当前的路由表冲突")
+)
+
+// SlotRange 一段连续的槽，左闭右开 [Start, End)
+type SlotRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Migration 把一段槽从 From 迁移到 To
+type Migration struct {
+	SlotRange
+	From *Node
+	To   *Node
+}
+
+func (m Migration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		SlotRange
+		From string `json:"from"`
+		To   string `json:"to"`
+	}{SlotRange: m.SlotRange, From: m.From.name, To: m.To.name})
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("[%d, %d) %s -> %s", m.Start, m.End, m.From.name, m.To.name)
+}
+
+// MigrationPlan 节点变更之后的迁移计划，调用方可以分批执行：
+// 先 BeginMigration，此时路由切换到新节点，旧节点作为回退；
+// 数据迁移完之后 CompleteMigration，去掉回退
+type MigrationPlan struct {
+	Migrations []Migration
+	// removed RemoveNode 删除的节点，AbortPlan 的时候如果它还有槽要放回节点列表
+	removed *Node
+}
+
+// MovedSlots 计划里面一共要迁移的槽数量
+func (p *MigrationPlan) MovedSlots() int {
+	cnt := 0
+	for _, m := range p.Migrations {
+		cnt += m.End - m.Start
+	}
+	return cnt
+}
+
+// AddNode 加入一个新节点，其它节点按照各自持有槽的比例分一部分槽给它，
+// 新节点分到的槽数量和它的权重成正比，只有这部分槽需要迁移。
+// 计划里面的槽在开始迁移或者 AbortPlan 之前都是预留的，这段时间 Balance 不会执行，
+// 别的 AddNode 也不会再分配这些槽。已经在迁移的槽同样不会分配
+func (h *HashRing) AddNode(node *Node) (*MigrationPlan, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.findNode(node.name) >= 0 {
+		return nil, ErrNodeExists
+	}
+	if h.nodeNum == 0 {
+		// 没有节点可以分槽给它
+		return nil, ErrNoNode
+	}
+
+	t := h.table.Load()
+	slots := t.slotOfNodes
+	owned := h.movableSlots(t)
+	movable := 0
+	for _, s := range owned {
+		movable += len(s)
+	}
+	need := min(h.slotNum*node.Weight()/(totalWeight(h.nodes)+node.Weight()), movable)
+	// 每个节点按照持有槽的比例贡献，除不尽的部分由持有最多的节点补上
+	give := make([]int, h.nodeNum)
+	total := 0
+	for i, n := range h.nodes {
+		give[i] = len(owned[n]) * need / max(movable, 1)
+		total += give[i]
+	}
+	for total < need {
+		idx := 0
+		for i, n := range h.nodes {
+			if len(owned[n])-give[i] > len(owned[h.nodes[idx]])-give[idx] {
+				idx = i
+			}
+		}
+		give[idx]++
+		total++
+	}
+
+	moves := make(map[int]*Node, need)
+	for i, n := range h.nodes {
+		// 从后往前拿，尽量拿到连续的槽
+		s := owned[n]
+		for _, slot := range s[len(s)-give[i]:] {
+			moves[slot] = node
+		}
+	}
+	h.nodes = append(h.nodes, node)
+	h.nodeNum = len(h.nodes)
+	h.refreshReplicas()
+	plan := buildPlan(slots, moves)
+	h.reserve(plan.Migrations, true)
+	return plan, nil
+}
+
+// RemoveNode 删除节点，它持有的槽按照权重分给剩下的节点。
+// 节点立刻不再参与 Balance，但是在迁移开始之前依旧负责它原本的槽。
+// 和 AddNode 一样，计划里面的槽是预留的
+func (h *HashRing) RemoveNode(name string) (*MigrationPlan, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	idx := h.findNode(name)
+	if idx < 0 {
+		return nil, ErrNodeNotFound
+	}
+	if h.nodeNum == 1 {
+		return nil, ErrLastNode
+	}
+	removed := h.nodes[idx]
+	t := h.table.Load()
+	slots := t.slotOfNodes
+	for slot, n := range slots {
+		if n == removed && (h.isReserved(slot) || (t.fallback != nil && t.fallback[slot] != nil)) {
+			return nil, fmt.Errorf("%w: 节点 %s 的槽 %d 还有没有完成的迁移计划", ErrMigrationConflict, name, slot)
+		}
+	}
+	remain := make([]*Node, 0, h.nodeNum-1)
+	remain = append(remain, h.nodes[:idx]...)
+	remain = append(remain, h.nodes[idx+1:]...)
+
+	owned := h.ownedSlots(slots)
+	moves := make(map[int]*Node, len(owned[removed]))
+	// 按顺序分成连续的几段，每个节点一段，段的长度和权重成正比
+	s := owned[removed]
+	weights := totalWeight(remain)
+	cum := 0
+	for _, n := range remain {
+		start := len(s) * cum / weights
+		cum += n.Weight()
+		end := len(s) * cum / weights
+		for _, slot := range s[start:end] {
+			moves[slot] = n
+		}
+	}
+	h.nodes = remain
+	h.nodeNum = len(remain)
+	h.refreshReplicas()
+	plan := buildPlan(slots, moves)
+	plan.removed = removed
+	h.reserve(plan.Migrations, true)
+	return plan, nil
+}
+
+// BeginMigration 开始迁移一段槽：路由切换到 To，From 作为回退
+func (h *HashRing) BeginMigration(m Migration) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	t := h.table.Load()
+	for slot := m.Start; slot < m.End; slot++ {
+		if t.slotOfNodes[slot] != m.From {
+			return fmt.Errorf("%w: 槽 %d 不属于节点 %s", ErrMigrationConflict, slot, m.From.name)
+		}
+	}
+	nt := t.clone()
+	for slot := m.Start; slot < m.End; slot++ {
+		nt.slotOfNodes[slot] = m.To
+		nt.fallback[slot] = m.From
+	}
+	h.storeTable(nt)
+	h.reserve([]Migration{m}, false)
+	return nil
+}
+
+// CompleteMigration 数据已经迁移完成，去掉回退
+func (h *HashRing) CompleteMigration(m Migration) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	t := h.table.Load()
+	for slot := m.Start; slot < m.End; slot++ {
+		if t.slotOfNodes[slot] != m.To || t.fallback == nil || t.fallback[slot] != m.From {
+			return fmt.Errorf("%w: 槽 %d 没有在从 %s 迁移到 %s", ErrMigrationConflict, slot, m.From.name, m.To.name)
+		}
+	}
+	nt := t.clone()
+	for slot := m.Start; slot < m.End; slot++ {
+		nt.fallback[slot] = nil
+	}
+	// 所有的迁移都完成了
+	if slices.IndexFunc(nt.fallback, func(n *Node) bool { return n != nil }) < 0 {
+		nt.fallback = nil
+	}
+	h.storeTable(nt)
+	return nil
+}
+
+// ApplyPlan 一次性执行整个迁移计划，适合不需要搬迁数据的场景
+func (h *HashRing) ApplyPlan(plan *MigrationPlan) error {
+	for _, m := range plan.Migrations {
+		if err := h.BeginMigration(m); err != nil {
+			return err
+		}
+		if err := h.CompleteMigration(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AbortPlan 放弃 AddNode 或者 RemoveNode 返回的计划里面还没有开始的迁移，释放预留的槽。
+// 已经开始的迁移不受影响，需要 CompleteMigration。
+// RemoveNode 删除的节点如果还有槽，会放回节点列表，重新参与 Balance
+func (h *HashRing) AbortPlan(plan *MigrationPlan) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.reserve(plan.Migrations, false)
+	removed := plan.removed
+	if removed == nil || h.findNode(removed.name) >= 0 ||
+		!slices.Contains(h.table.Load().slotOfNodes, removed) {
+		return
+	}
+	h.nodes = append(h.nodes, removed)
+	h.nodeNum = len(h.nodes)
+	h.refreshReplicas()
+}
+
+// reserve 预留或者释放 migrations 里面的槽，调用方必须持有 h.lock
+func (h *HashRing) reserve(migrations []Migration, reserved bool) {
+	if h.reserved == nil {
+		if !reserved {
+			return
+		}
+		h.reserved = make([]bool, h.slotNum)
+	}
+	for _, m := range migrations {
+		for slot := m.Start; slot < m.End; slot++ {
+			if h.reserved[slot] == reserved {
+				continue
+			}
+			h.reserved[slot] = reserved
+			if reserved {
+				h.reservedNum++
+			} else {
+				h.reservedNum--
+			}
+		}
+	}
+}
+
+func (h *HashRing) isReserved(slot int) bool {
+	return h.reserved != nil && h.reserved[slot]
+}
+
+func (h *HashRing) findNode(name string) int {
+	for i, n := range h.nodes {
+		if n.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// ownedSlots 每个节点持有的槽，从小到大排列
+func (h *HashRing) ownedSlots(slots []*Node) map[*Node][]int {
+	res := make(map[*Node][]int, h.nodeNum)
+	for slot, n := range slots {
+		res[n] = append(res[n], slot)
+	}
+	return res
+}
+
+// movableSlots 和 ownedSlots 一样，但是跳过正在迁移和已经被计划预留的槽
+func (h *HashRing) movableSlots(t *routeTable) map[*Node][]int {
+	res := make(map[*Node][]int, h.nodeNum)
+	for slot, n := range t.slotOfNodes {
+		if h.isReserved(slot) || (t.fallback != nil && t.fallback[slot] != nil) {
+			continue
+		}
+		res[n] = append(res[n], slot)
+	}
+	return res
+}
+
+// buildPlan 把要迁移的槽合并成连续的几段
+func buildPlan(slots []*Node, moves map[int]*Node) *MigrationPlan {
+	plan := &MigrationPlan{}
+	for slot := 0; slot < len(slots); slot++ {
+		to, ok := moves[slot]
+		if !ok {
+			continue
+		}
+		from := slots[slot]
+		cnt := len(plan.Migrations)
+		if cnt > 0 {
+			last := &plan.Migrations[cnt-1]
+			if last.End == slot && last.From == from && last.To == to {
+				last.End++
+				continue
+			}
+		}
+		plan.Migrations = append(plan.Migrations, Migration{
+			SlotRange: SlotRange{Start: slot, End: slot + 1},
+			From:      from,
+			To:        to,
+		})
+	}
+	return plan
+}
@@ -0,0 +1,203 @@
+package case12
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRing(slotNum int, names ...string) (*HashRing, []*Node) {
+	nodes := make([]*Node, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, &Node{name: name, address: name + "_address"})
+	}
+	return NewHashRing(nodes, slotNum, func(req any) int {
+		return req.(int) % slotNum
+	}), nodes
+}
+
+func TestHashRing_AddNode(t *testing.T) {
+	h, nodes := newTestRing(12, "a", "b", "c")
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	d := &Node{name: "d", address: "d_address"}
+
+	plan, err := h.AddNode(d)
+	require.NoError(t, err)
+	// 每个节点拿出最后一个槽
+	assert.Equal(t, []Migration{
+		{SlotRange: SlotRange{Start: 3, End: 4}, From: a, To: d},
+		{SlotRange: SlotRange{Start: 7, End: 8}, From: b, To: d},
+		{SlotRange: SlotRange{Start: 11, End: 12}, From: c, To: d},
+	}, plan.Migrations)
+	assert.Equal(t, 3, plan.MovedSlots())
+
+	_, err = h.AddNode(&Node{name: "d"})
+	assert.Equal(t, ErrNodeExists, err)
+
+	// 迁移开始之前，路由不变
+	assert.Equal(t, a, h.GetNode(3))
+
+	// 开始迁移第一段，读新节点，旧节点作为回退
+	err = h.BeginMigration(plan.Migrations[0])
+	require.NoError(t, err)
+	n, fallback := h.GetNodeWithFallback(3)
+	assert.Equal(t, d, n)
+	assert.Equal(t, a, fallback)
+	n, fallback = h.GetNodeWithFallback(7)
+	assert.Equal(t, b, n)
+	assert.Nil(t, fallback)
+
+	// 重复开始会冲突
+	err = h.BeginMigration(plan.Migrations[0])
+	assert.ErrorIs(t, err, ErrMigrationConflict)
+	// 迁移过程中 Balance 不会改变路由
+	h.Balance()
+	assert.Equal(t, d, h.GetNode(3))
+
+	err = h.CompleteMigration(plan.Migrations[0])
+	require.NoError(t, err)
+	n, fallback = h.GetNodeWithFallback(3)
+	assert.Equal(t, d, n)
+	assert.Nil(t, fallback)
+	err = h.CompleteMigration(plan.Migrations[0])
+	assert.ErrorIs(t, err, ErrMigrationConflict)
+
+	// 剩下的一次性执行
+	err = h.ApplyPlan(&MigrationPlan{Migrations: plan.Migrations[1:]})
+	require.NoError(t, err)
+	assert.Equal(t, d, h.GetNode(7))
+	assert.Equal(t, d, h.GetNode(11))
+	assert.Equal(t, c, h.GetNode(10))
+}
+
+func TestHashRing_RemoveNode(t *testing.T) {
+	h, nodes := newTestRing(12, "a", "b", "c")
+	a, b, c := nodes[0], nodes[1], nodes[2]
+
+	plan, err := h.RemoveNode("b")
+	require.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{SlotRange: SlotRange{Start: 4, End: 6}, From: b, To: a},
+		{SlotRange: SlotRange{Start: 6, End: 8}, From: b, To: c},
+	}, plan.Migrations)
+	// 迁移开始之前还是由 b 负责
+	assert.Equal(t, b, h.GetNode(5))
+
+	err = h.ApplyPlan(plan)
+	require.NoError(t, err)
+	assert.Equal(t, a, h.GetNode(5))
+	assert.Equal(t, c, h.GetNode(6))
+
+	_, err = h.RemoveNode("b")
+	assert.Equal(t, ErrNodeNotFound, err)
+	_, err = h.RemoveNode("a")
+	require.NoError(t, err)
+	_, err = h.RemoveNode("c")
+	assert.Equal(t, ErrLastNode, err)
+}
+
+// TestHashRing_ReservePlan AddNode 之后、执行计划之前，Balance 不能改变路由，不然计划就过期了
+func TestHashRing_ReservePlan(t *testing.T) {
+	h, nodes := newTestRing(12, "a", "b", "c")
+	a := nodes[0]
+	// 负载都在 a 上面，没有预留的时候 Balance 会迁移
+	loads := make([]int, 12)
+	for i := 0; i < 4; i++ {
+		loads[i] = 100
+	}
+
+	d := &Node{name: "d", address: "d_address"}
+	plan, err := h.AddNode(d)
+	require.NoError(t, err)
+	h.SetRequestNumOfSlot(loads)
+	assert.Empty(t, h.Balance().Migrations)
+	assert.Equal(t, a, h.GetNode(0))
+
+	// 计划依旧可以执行
+	require.NoError(t, h.ApplyPlan(plan))
+	assert.Equal(t, d, h.GetNode(3))
+	h.SetRequestNumOfSlot(loads)
+	assert.NotEmpty(t, h.Balance().Migrations)
+
+	// 放弃计划之后也可以 Balance
+	h, _ = newTestRing(12, "a", "b", "c")
+	plan, err = h.RemoveNode("c")
+	require.NoError(t, err)
+	h.SetRequestNumOfSlot(loads)
+	assert.Empty(t, h.Balance().Migrations)
+	h.AbortPlan(plan)
+	// c 还有槽，放回节点列表
+	assert.Equal(t, []string{"a", "b", "c"}, nodeNames(h.Nodes()))
+	h.SetRequestNumOfSlot(loads)
+	assert.NotEmpty(t, h.Balance().Migrations)
+}
+
+// TestHashRing_OverlappingPlans 前一个计划还没有开始的时候再变更节点
+func TestHashRing_OverlappingPlans(t *testing.T) {
+	h, _ := newTestRing(12, "a", "b", "c")
+	d, e := NewNode("d", "d_address", 1), NewNode("e", "e_address", 1)
+	p1, err := h.AddNode(d)
+	require.NoError(t, err)
+	p2, err := h.AddNode(e)
+	require.NoError(t, err)
+	// 第二个计划不会再分配第一个计划里面的槽
+	planned := make(map[int]bool)
+	for _, m := range p1.Migrations {
+		for slot := m.Start; slot < m.End; slot++ {
+			planned[slot] = true
+		}
+	}
+	for _, m := range p2.Migrations {
+		for slot := m.Start; slot < m.End; slot++ {
+			assert.False(t, planned[slot], "槽 %d", slot)
+		}
+	}
+	assert.NotZero(t, p2.MovedSlots())
+	require.NoError(t, h.ApplyPlan(p1))
+	require.NoError(t, h.ApplyPlan(p2))
+	assert.Equal(t, 0, h.reservedNum)
+
+	// 节点上有没有执行的计划的时候不能删除
+	p3, err := h.AddNode(NewNode("f", "f_address", 1))
+	require.NoError(t, err)
+	_, err = h.RemoveNode(p3.Migrations[0].From.name)
+	assert.ErrorIs(t, err, ErrMigrationConflict)
+	h.AbortPlan(p3)
+
+	// 没有节点的时候不能加入
+	empty := NewHashRing(nil, 12, func(req any) int { return req.(int) })
+	_, err = empty.AddNode(d)
+	assert.ErrorIs(t, err, ErrNoNode)
+}
+
+func nodeNames(nodes []*Node) []string {
+	res := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		res = append(res, n.Name())
+	}
+	return res
+}
+
+// TestHashRing_JoinBalance Join 和 Balance 并发执行，路由表始终是一致的
+func TestHashRing_JoinBalance(t *testing.T) {
+	h, _ := newTestRing(64, "a", "b")
+	loads := make([]int, 64)
+	for i := range loads {
+		loads[i] = i
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			h.SetRequestNumOfSlot(loads)
+			h.Balance()
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, h.Join(&Node{name: fmt.Sprintf("n%d", i)}))
+	}
+	<-done
+	assert.Len(t, h.Nodes(), 12)
+}
@@ -0,0 +1,236 @@
+package case12
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrBackendNotScannable = errors.New("节点的缓存不支持遍历，无法迁移数据")
+
+// MigrationProgress 迁移的进度，每迁移完一段槽回调一次
+type MigrationProgress struct {
+	// Migration 刚刚完成的这一段
+	Migration Migration
+	// CompletedSlots 和 TotalSlots 已经完成的槽和整个计划的槽
+	CompletedSlots int
+	TotalSlots     int
+	// CopiedKeys 到目前为止搬到新节点上的 key 数量
+	CopiedKeys int
+}
+
+// Migrator 槽换了节点之后，把旧节点上的缓存搬到新节点上。
+// 搬迁的过程中路由已经切换到新节点，读的时候新节点没有就回退到旧节点读，避免缓存被击穿。
+// HashRing 需要用 KeyHashCodeFunc 之类支持 string 的 HashCodeFunc，节点的缓存需要实现 ScannableBackend
+type Migrator struct {
+	ring *HashRing
+	// lock 同一时刻只执行一个迁移计划
+	lock sync.Mutex
+	// keyLock 搬迁一个 key 的时候持有写锁，Set 和 Delete 持有读锁，
+	// 保证检查 dirty 和写新节点之间不会插进来别的写操作
+	keyLock sync.RWMutex
+	// tracking 正在执行迁移计划，这个时候要记录 dirty
+	tracking bool
+	// dirty 迁移期间通过 Set 和 Delete 改过的 key，搬迁的时候跳过，
+	// 不然旧节点上的数据会覆盖新写的数据，或者把已经删掉的 key 又复制到新节点
+	dirty sync.Map
+}
+
+func NewMigrator(ring *HashRing) *Migrator {
+	return &Migrator{ring: ring}
+}
+
+// Migrate 迁移计划里面的槽：切换路由，搬迁数据，删除旧节点上的数据，最后去掉回退。
+// 同一个旧节点的所有槽一起切换，只遍历一次旧节点，每完成一段槽回调一次 onProgress，onProgress 可以为 nil。
+// 中途出错的时候，正在迁移的槽保持双读的状态，用同一个计划再调用一次 Migrate 可以继续
+func (m *Migrator) Migrate(ctx context.Context, plan *MigrationPlan,
+	onProgress func(MigrationProgress)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.track(true)
+	defer m.track(false)
+
+	progress := MigrationProgress{TotalSlots: plan.MovedSlots()}
+	// 按照旧节点分组，保持计划里面的顺序
+	var froms []*Node
+	groups := make(map[*Node][]Migration)
+	for _, mg := range plan.Migrations {
+		if m.completed(mg) {
+			progress.CompletedSlots += mg.End - mg.Start
+			continue
+		}
+		if _, ok := groups[mg.From]; !ok {
+			froms = append(froms, mg.From)
+		}
+		groups[mg.From] = append(groups[mg.From], mg)
+	}
+
+	for _, from := range froms {
+		mgs := groups[from]
+		copied, err := m.migrate(ctx, from, mgs)
+		if err != nil {
+			return fmt.Errorf("迁移节点 %s 上的数据失败: %w", from.name, err)
+		}
+		for i, mg := range mgs {
+			if err = m.ring.CompleteMigration(mg); err != nil {
+				return fmt.Errorf("迁移 %s 失败: %w", mg, err)
+			}
+			progress.Migration = mg
+			progress.CompletedSlots += mg.End - mg.Start
+			progress.CopiedKeys += copied[i]
+			if onProgress != nil {
+				onProgress(progress)
+			}
+		}
+	}
+	return nil
+}
+
+// migrate 切换 mgs 的路由，遍历一次 from，把属于 mgs 的 key 搬到对应的新节点上。
+// 返回每一段搬过去的 key 数量
+func (m *Migrator) migrate(ctx context.Context, from *Node, mgs []Migration) ([]int, error) {
+	src, ok := from.Cache().(ScannableBackend)
+	if !ok {
+		return nil, ErrBackendNotScannable
+	}
+	// 槽在 mgs 里面的下标
+	idx := make(map[int]int)
+	for i, mg := range mgs {
+		if !m.started(mg) {
+			if err := m.ring.BeginMigration(mg); err != nil {
+				return nil, err
+			}
+		}
+		for slot := mg.Start; slot < mg.End; slot++ {
+			idx[slot] = i
+		}
+	}
+
+	copied := make([]int, len(mgs))
+	err := src.Scan(ctx, func(key string, val []byte, ttl time.Duration) error {
+		slot, err := m.ring.slotOf(key)
+		if err != nil {
+			return nil
+		}
+		i, ok := idx[slot]
+		if !ok {
+			// 不属于这一次迁移的槽
+			return nil
+		}
+		ok, err = m.copy(ctx, mgs[i].To.Cache(), key, val, ttl)
+		if err != nil {
+			return err
+		}
+		if ok {
+			copied[i]++
+		}
+		return src.Delete(ctx, key)
+	})
+	return copied, err
+}
+
+// copy 把旧节点上的 key 复制到新节点，返回是否真的复制了
+func (m *Migrator) copy(ctx context.Context, to CacheBackend, key string, val []byte, ttl time.Duration) (bool, error) {
+	m.keyLock.Lock()
+	defer m.keyLock.Unlock()
+	// 切换之后写过或者删过，以新节点为准
+	if _, ok := m.dirty.Load(key); ok {
+		return false, nil
+	}
+	_, err := to.Get(ctx, key)
+	if !errors.Is(err, ErrCacheMiss) {
+		// 新节点上已经有了，同样以新节点为准
+		return false, err
+	}
+	return true, to.Set(ctx, key, val, ttl)
+}
+
+// track 开始或者结束记录 dirty，结束的时候清空
+func (m *Migrator) track(on bool) {
+	m.keyLock.Lock()
+	defer m.keyLock.Unlock()
+	m.tracking = on
+	if !on {
+		m.dirty.Range(func(key, _ any) bool {
+			m.dirty.Delete(key)
+			return true
+		})
+	}
+}
+
+// markDirty 调用方必须持有 keyLock 的读锁
+func (m *Migrator) markDirty(key string) {
+	if m.tracking {
+		m.dirty.Store(key, struct{}{})
+	}
+}
+
+// started 这一段已经 BeginMigration 了，上一次 Migrate 在搬迁数据的时候出错了
+func (m *Migrator) started(mg Migration) bool {
+	t := m.ring.table.Load()
+	if t.fallback == nil {
+		return false
+	}
+	for slot := mg.Start; slot < mg.End; slot++ {
+		if t.slotOfNodes[slot] != mg.To || t.fallback[slot] != mg.From {
+			return false
+		}
+	}
+	return true
+}
+
+// completed 这一段已经迁移完了
+func (m *Migrator) completed(mg Migration) bool {
+	t := m.ring.table.Load()
+	for slot := mg.Start; slot < mg.End; slot++ {
+		if t.slotOfNodes[slot] != mg.To || (t.fallback != nil && t.fallback[slot] != nil) {
+			return false
+		}
+	}
+	return true
+}
+
+// Get 读 key 所在节点的缓存，槽正在迁移并且新节点上没有的时候读旧节点
+func (m *Migrator) Get(ctx context.Context, key string) ([]byte, error) {
+	node, fallback, err := m.ring.GetNodeByKeyWithFallback(key)
+	if err != nil {
+		return nil, err
+	}
+	val, err := node.Cache().Get(ctx, key)
+	if errors.Is(err, ErrCacheMiss) && fallback != nil {
+		return fallback.Cache().Get(ctx, key)
+	}
+	return val, err
+}
+
+// Set 写 key 所在节点的缓存，迁移的时候不会被旧节点上的数据覆盖。
+// 必须通过 Migrator 写，直接写节点的缓存不会记录 dirty
+func (m *Migrator) Set(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	m.keyLock.RLock()
+	defer m.keyLock.RUnlock()
+	m.markDirty(key)
+	node, err := m.ring.GetNodeByKey(key)
+	if err != nil {
+		return err
+	}
+	return node.Cache().Set(ctx, key, val, ttl)
+}
+
+// Delete 删除 key，槽正在迁移的时候旧节点上的也要删除，不然双读的时候会读到
+func (m *Migrator) Delete(ctx context.Context, key string) error {
+	m.keyLock.RLock()
+	defer m.keyLock.RUnlock()
+	m.markDirty(key)
+	node, fallback, err := m.ring.GetNodeByKeyWithFallback(key)
+	if err != nil {
+		return err
+	}
+	if fallback != nil {
+		if err = fallback.Cache().Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return node.Cache().Delete(ctx, key)
+}
@@ -0,0 +1,286 @@
+package case12
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMigrationRing(names ...string) *HashRing {
+	nodes := make([]*Node, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, NewNodeWithCache(name, name+"_address", 1, NewLRUBackend(1000)))
+	}
+	return NewHashRing(nodes, 16, KeyHashCodeFunc(CRC16, 16))
+}
+
+func TestMigrator_Migrate(t *testing.T) {
+	ctx := context.Background()
+	h := newMigrationRing("a", "b")
+	m := NewMigrator(h)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, m.Set(ctx, fmt.Sprintf("key_%d", i), []byte(fmt.Sprintf("val_%d", i)), time.Minute))
+	}
+
+	c := NewNodeWithCache("c", "c_address", 2, NewLRUBackend(1000))
+	plan, err := h.AddNode(c)
+	require.NoError(t, err)
+	var progresses []MigrationProgress
+	err = m.Migrate(ctx, plan, func(p MigrationProgress) {
+		progresses = append(progresses, p)
+	})
+	require.NoError(t, err)
+
+	require.Len(t, progresses, len(plan.Migrations))
+	last := progresses[len(progresses)-1]
+	assert.Equal(t, plan.MovedSlots(), last.CompletedSlots)
+	assert.Equal(t, plan.MovedSlots(), last.TotalSlots)
+
+	moved := 0
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		val, err := m.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("val_%d", i)), val)
+
+		// 数据只在负责它的节点上
+		owner, err := h.GetNodeByKey(key)
+		require.NoError(t, err)
+		for _, n := range h.Nodes() {
+			_, err = n.Cache().Get(ctx, key)
+			if n == owner {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, ErrCacheMiss, err)
+			}
+		}
+		if owner == c {
+			moved++
+		}
+	}
+	assert.Equal(t, moved, last.CopiedKeys)
+	assert.Greater(t, moved, 0)
+}
+
+func TestMigrator_DoubleRead(t *testing.T) {
+	ctx := context.Background()
+	h := newMigrationRing("a", "b")
+	m := NewMigrator(h)
+	require.NoError(t, m.Set(ctx, "foo", []byte("old"), 0))
+	require.NoError(t, m.Set(ctx, "bar", []byte("old"), 0))
+	from, err := h.GetNodeByKey("foo")
+	require.NoError(t, err)
+
+	c := NewNodeWithCache("c", "c_address", 1, NewLRUBackend(1000))
+	// 不用 AddNode 的计划，手动把 foo 和 bar 所在的槽迁到 c
+	_, err = h.AddNode(c)
+	require.NoError(t, err)
+	plan := &MigrationPlan{}
+	for _, key := range []string{"foo", "bar"} {
+		slot, err := h.slotOf(key)
+		require.NoError(t, err)
+		owner, err := h.GetNodeByKey(key)
+		require.NoError(t, err)
+		plan.Migrations = append(plan.Migrations, Migration{
+			SlotRange: SlotRange{Start: slot, End: slot + 1}, From: owner, To: c,
+		})
+	}
+	for _, mg := range plan.Migrations {
+		require.NoError(t, h.BeginMigration(mg))
+	}
+
+	// 路由已经切换，新节点上没有，从旧节点读
+	n, err := h.GetNodeByKey("foo")
+	require.NoError(t, err)
+	assert.Equal(t, c, n)
+	val, err := m.Get(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("old"), val)
+
+	// 切换之后写入的数据不会被旧节点上的覆盖
+	require.NoError(t, m.Set(ctx, "foo", []byte("new"), 0))
+	// 删除的时候旧节点上的也删掉，不然双读会读到
+	require.NoError(t, m.Delete(ctx, "bar"))
+	_, err = m.Get(ctx, "bar")
+	assert.Equal(t, ErrCacheMiss, err)
+
+	require.NoError(t, m.Migrate(ctx, plan, nil))
+	val, err = m.Get(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new"), val)
+	_, err = from.Cache().Get(ctx, "foo")
+	assert.Equal(t, ErrCacheMiss, err)
+}
+
+// failingBackend Set 失败指定的次数
+type failingBackend struct {
+	*LRUBackend
+	fails int
+}
+
+func (f *failingBackend) Set(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	if f.fails > 0 {
+		f.fails--
+		return errors.New("mock error")
+	}
+	return f.LRUBackend.Set(ctx, key, val, ttl)
+}
+
+func TestMigrator_Resume(t *testing.T) {
+	ctx := context.Background()
+	h := newMigrationRing("a")
+	m := NewMigrator(h)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, m.Set(ctx, fmt.Sprintf("key_%d", i), []byte("val"), 0))
+	}
+
+	b := NewNodeWithCache("b", "b_address", 1, &failingBackend{LRUBackend: NewLRUBackend(1000), fails: 1})
+	plan, err := h.AddNode(b)
+	require.NoError(t, err)
+	err = m.Migrate(ctx, plan, nil)
+	assert.Error(t, err)
+
+	// 出错的时候依旧可以双读
+	for i := 0; i < 20; i++ {
+		val, err := m.Get(ctx, fmt.Sprintf("key_%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("val"), val)
+	}
+	// 不完成迁移不能 Balance
+	assert.Equal(t, 0, h.Balance().MovedSlots())
+
+	// 用同一个计划继续
+	require.NoError(t, m.Migrate(ctx, plan, nil))
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		owner, err := h.GetNodeByKey(key)
+		require.NoError(t, err)
+		val, err := owner.Cache().Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("val"), val)
+	}
+	assert.Nil(t, h.table.Load().fallback)
+}
+
+// hookBackend 遍历之前先执行 beforeScan，记录遍历的次数
+type hookBackend struct {
+	*LRUBackend
+	beforeScan func()
+	scans      int
+}
+
+func (b *hookBackend) Scan(ctx context.Context, fn func(key string, val []byte, ttl time.Duration) error) error {
+	b.scans++
+	if b.beforeScan != nil {
+		b.beforeScan()
+	}
+	return b.LRUBackend.Scan(ctx, fn)
+}
+
+func TestMigrator_DeleteBeforeScan(t *testing.T) {
+	ctx := context.Background()
+	src := &hookBackend{LRUBackend: NewLRUBackend(1000)}
+	a := NewNodeWithCache("a", "a_address", 1, src)
+	h := NewHashRing([]*Node{a}, 16, KeyHashCodeFunc(CRC16, 16))
+	m := NewMigrator(h)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, m.Set(ctx, fmt.Sprintf("key_%d", i), []byte("val"), 0))
+	}
+	plan, err := h.AddNode(NewNodeWithCache("b", "b_address", 1, NewLRUBackend(1000)))
+	require.NoError(t, err)
+	// 已经 BeginMigration，还没有开始遍历旧节点的时候删除和修改
+	src.beforeScan = func() {
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("key_%d", i)
+			if i%2 == 0 {
+				require.NoError(t, m.Delete(ctx, key))
+			} else {
+				require.NoError(t, m.Set(ctx, key, []byte("new"), 0))
+			}
+		}
+		// 模拟删除和遍历并发：遍历已经读到了旧数据，旧节点上的删除在这之后才执行
+		for i := 0; i < 20; i += 2 {
+			require.NoError(t, src.LRUBackend.Set(ctx, fmt.Sprintf("key_%d", i), []byte("val"), 0))
+		}
+	}
+	require.NoError(t, m.Migrate(ctx, plan, nil))
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		owner, err := h.GetNodeByKey(key)
+		require.NoError(t, err)
+		val, err := m.Get(ctx, key)
+		if i%2 == 0 {
+			// 删掉的 key 不会被复制到新节点
+			if owner != a {
+				assert.Equal(t, ErrCacheMiss, err, key)
+			}
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, []byte("new"), val, key)
+	}
+}
+
+func TestMigrator_ScanOnce(t *testing.T) {
+	ctx := context.Background()
+	src := &hookBackend{LRUBackend: NewLRUBackend(1000)}
+	a := NewNodeWithCache("a", "a_address", 1, src)
+	h := NewHashRing([]*Node{a}, 16, KeyHashCodeFunc(CRC16, 16))
+	m := NewMigrator(h)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, m.Set(ctx, fmt.Sprintf("key_%d", i), []byte("val"), 0))
+	}
+	// 交替分配，计划里面有多段从 a 迁出的槽
+	b := NewNodeWithCache("b", "b_address", 1, NewLRUBackend(1000))
+	plan := &MigrationPlan{}
+	for slot := 0; slot < 16; slot += 2 {
+		plan.Migrations = append(plan.Migrations, Migration{
+			SlotRange: SlotRange{Start: slot, End: slot + 1}, From: a, To: b,
+		})
+	}
+	var progresses []MigrationProgress
+	require.NoError(t, m.Migrate(ctx, plan, func(p MigrationProgress) {
+		progresses = append(progresses, p)
+	}))
+	assert.Equal(t, 1, src.scans)
+	require.Len(t, progresses, len(plan.Migrations))
+	assert.Equal(t, 8, progresses[len(progresses)-1].CompletedSlots)
+	for i := 0; i < 100; i++ {
+		val, err := m.Get(ctx, fmt.Sprintf("key_%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("val"), val)
+	}
+}
+
+// plainBackend 不支持遍历的缓存
+type plainBackend struct {
+	CacheBackend
+}
+
+func TestMigrator_NotScannable(t *testing.T) {
+	a := NewNodeWithCache("a", "a_address", 1, plainBackend{CacheBackend: NewLRUBackend(10)})
+	h := NewHashRing([]*Node{a}, 16, KeyHashCodeFunc(CRC16, 16))
+	plan, err := h.AddNode(NewNode("b", "b_address", 1))
+	require.NoError(t, err)
+	err = NewMigrator(h).Migrate(context.Background(), plan, nil)
+	assert.ErrorIs(t, err, ErrBackendNotScannable)
+	// 没有切换路由
+	assert.Equal(t, map[*Node]int{a: 16}, slotCounts(h))
+}
+
+func TestHashRing_PlanBalance(t *testing.T) {
+	h, nodes := newTestRing(10, "a", "b", "c")
+	h.SetRequestNumOfSlot([]int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100})
+	plan := h.PlanBalance()
+	assert.Equal(t, 4, plan.MovedSlots())
+	// 还没有切换路由
+	assert.Equal(t, map[*Node]int{nodes[0]: 3, nodes[1]: 3, nodes[2]: 4}, slotCounts(h))
+	require.NoError(t, h.ApplyPlan(plan))
+	assert.Equal(t, 0, h.PlanBalance().MovedSlots())
+}
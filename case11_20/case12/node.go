@@ -1,37 +1,93 @@
 package case12
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 )
 
+// DefaultNodeWeight 没有设置权重的节点使用的权重
+const DefaultNodeWeight = 1
+
 type Node struct {
 	name    string
 	address string
+	// weight 节点的容量权重，机器越好权重越大，分到的请求越多。小于等于 0 的时候使用默认权重
+	weight atomic.Int64
+	// cache 节点背后的缓存，见 Cache
+	cache     CacheBackend
+	cacheOnce sync.Once
+	// zone 节点所在的机房，ReadNearest 的时候优先选同一个机房的节点
+	zone string
+	// down 节点被标记为下线，路由的时候跳过
+	down atomic.Bool
 }
 
-func (n *Node) GetCache(uid int) (string, error) {
-	fileName := fmt.Sprintf("cache/c_%s_%d.txt", n.address, uid)
-	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
+func NewNode(name, address string, weight int) *Node {
+	n := &Node{name: name, address: address}
+	n.weight.Store(int64(weight))
+	return n
+}
 
-	// 读取文件内容
-	content, err := io.ReadAll(file)
-	if err != nil {
-		return "", err
+// NewNodeWithCache 创建一个使用 cache 作为缓存的节点
+func NewNodeWithCache(name, address string, weight int, cache CacheBackend) *Node {
+	n := NewNode(name, address, weight)
+	n.cache = cache
+	return n
+}
+
+func (n *Node) Name() string {
+	return n.name
+}
+
+// Weight 节点的容量权重
+func (n *Node) Weight() int {
+	w := int(n.weight.Load())
+	if w <= 0 {
+		return DefaultNodeWeight
 	}
+	return w
+}
 
-	if len(content) == 0 {
+// SetZone 设置节点所在的机房，需要在加入 HashRing 之前设置
+func (n *Node) SetZone(zone string) {
+	n.zone = zone
+}
+
+func (n *Node) Zone() string {
+	return n.zone
+}
+
+// Available 节点没有被标记为下线
+func (n *Node) Available() bool {
+	return !n.down.Load()
+}
+
+// Cache 节点背后的缓存，没有设置的时候使用容量为 DefaultLRUCapacity 的内存缓存
+func (n *Node) Cache() CacheBackend {
+	n.cacheOnce.Do(func() {
+		if n.cache == nil {
+			n.cache = NewLRUBackend(DefaultLRUCapacity)
+		}
+	})
+	return n.cache
+}
+
+func (n *Node) GetCache(ctx context.Context, uid int) (string, error) {
+	key := strconv.Itoa(uid)
+	content, err := n.Cache().Get(ctx, key)
+	if errors.Is(err, ErrCacheMiss) {
 		cache := fmt.Sprintf("节点%s缓存", n.name)
-		_, err = file.WriteString(cache)
+		err = n.Cache().Set(ctx, key, []byte(cache), 0)
 		if err != nil {
 			return "", err
 		}
 		content = []byte(cache)
+	} else if err != nil {
+		return "", err
 	}
 
 	return string(content), nil
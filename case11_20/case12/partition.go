@@ -0,0 +1,129 @@
+package case12
+
+import "sort"
+
+// partitionByLoad 把 loads 按顺序切分成 len(weights) 段，返回每一段的结束位置（不包含）。
+// 目标是让所有段里面最大的 段请求数/段权重 最小：
+// 二分查找这个最大值 λ，对于给定的 λ，贪心地让每一段尽可能长，只要不超过 λ*权重，
+// 所有的槽都能分完就说明 λ 可行。整体复杂度是 O(slotNum * log(total))。
+// 这样得到的划分前面的段会很满，后面的段可能偏空，
+// 所以再按照前缀和逼近每一段的目标值切一次，两种切法里面选最大值更小、偏差更小的那个
+func partitionByLoad(loads []int, weights []int) []int {
+	prefixSum := prefixSums(loads)
+	total := prefixSum[len(loads)]
+	totalW := 0
+	minW := weights[0]
+	for _, w := range weights {
+		totalW += w
+		minW = min(minW, w)
+	}
+
+	byTarget := cutByTarget(prefixSum, weights, totalW)
+	if total == 0 {
+		return byTarget
+	}
+
+	lo, hi := 0.0, float64(total)/float64(minW)
+	// 精度到 1/totalW 个请求就足够区分不同的切法了
+	for hi-lo > 1/float64(totalW) {
+		mid := (lo + hi) / 2
+		if _, ok := cutGreedy(loads, weights, mid); ok {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	byMax, _ := cutGreedy(loads, weights, hi)
+
+	if better(prefixSum, weights, totalW, byTarget, byMax) {
+		return byTarget
+	}
+	return byMax
+}
+
+// cutGreedy 每一段的请求数不超过 limit*权重 的前提下尽量长，返回是否能分完
+func cutGreedy(loads []int, weights []int, limit float64) ([]int, bool) {
+	ends := make([]int, len(weights))
+	i := 0
+	for j, w := range weights {
+		capacity := limit * float64(w)
+		sum := 0
+		for i < len(loads) && float64(sum+loads[i]) <= capacity {
+			sum += loads[i]
+			i++
+		}
+		ends[j] = i
+	}
+	return ends, i == len(loads)
+}
+
+// cutByTarget 第 j 段在前缀和最接近前 j 个节点目标值之和的位置结束
+func cutByTarget(prefixSum []int, weights []int, totalW int) []int {
+	slotNum := len(prefixSum) - 1
+	total := prefixSum[slotNum]
+	ends := make([]int, len(weights))
+	cumW := 0
+	prev := 0
+	for j, w := range weights {
+		cumW += w
+		if j == len(weights)-1 {
+			ends[j] = slotNum
+			break
+		}
+		if total == 0 {
+			// 没有请求的时候按照权重切分槽的数量
+			ends[j] = max(prev, slotNum*cumW/totalW)
+			prev = ends[j]
+			continue
+		}
+		target := float64(total) * float64(cumW) / float64(totalW)
+		// 第一个前缀和大于等于目标值的位置，再和前一个位置比较哪个更接近
+		idx := sort.Search(len(prefixSum), func(i int) bool {
+			return float64(prefixSum[i]) >= target
+		})
+		if idx > 0 && (idx == len(prefixSum) || target-float64(prefixSum[idx-1]) <= float64(prefixSum[idx])-target) {
+			idx--
+		}
+		ends[j] = max(prev, min(idx, slotNum))
+		prev = ends[j]
+	}
+	return ends
+}
+
+// better a 的最大 段请求数/段权重 比 b 小，或者一样大但是总偏差更小
+func better(prefixSum []int, weights []int, totalW int, a, b []int) bool {
+	maxA, devA := evaluate(prefixSum, weights, totalW, a)
+	maxB, devB := evaluate(prefixSum, weights, totalW, b)
+	if maxA != maxB {
+		return maxA < maxB
+	}
+	return devA <= devB
+}
+
+// evaluate 返回最大的 段请求数/段权重，以及每一段和目标值的偏差之和
+func evaluate(prefixSum []int, weights []int, totalW int, ends []int) (float64, float64) {
+	total := float64(prefixSum[len(prefixSum)-1])
+	var maxLoad, dev float64
+	start := 0
+	for j, end := range ends {
+		sum := float64(prefixSum[end] - prefixSum[start])
+		maxLoad = max(maxLoad, sum/float64(weights[j]))
+		target := total * float64(weights[j]) / float64(totalW)
+		if sum > target {
+			dev += sum - target
+		} else {
+			dev += target - sum
+		}
+		start = end
+	}
+	return maxLoad, dev
+}
+
+// 计算数组的部分和
+func prefixSums(requestNumOfSlot []int) []int {
+	prefixSum := make([]int, len(requestNumOfSlot)+1)
+	for i := 1; i <= len(requestNumOfSlot); i++ {
+		prefixSum[i] = prefixSum[i-1] + requestNumOfSlot[i-1]
+	}
+	return prefixSum
+}
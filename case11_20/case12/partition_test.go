@@ -0,0 +1,120 @@
+package case12
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionByLoad(t *testing.T) {
+	testCases := []struct {
+		name    string
+		loads   []int
+		weights []int
+		want    []int
+	}{
+		{
+			name:    "递增的负载",
+			loads:   []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100},
+			weights: []int{1, 1, 1},
+			want:    []int{6, 8, 10},
+		},
+		{
+			name:    "没有请求按照权重切分",
+			loads:   make([]int, 8),
+			weights: []int{1, 3},
+			want:    []int{2, 8},
+		},
+		{
+			name:    "按照权重切分负载",
+			loads:   []int{10, 10, 10, 10, 10, 10, 10, 10},
+			weights: []int{1, 3},
+			want:    []int{2, 8},
+		},
+		{
+			name:    "一个槽的负载超过了平均值",
+			loads:   []int{1, 1, 100, 1, 1, 1},
+			weights: []int{1, 1, 1},
+			want:    []int{2, 3, 6},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, partitionByLoad(tc.loads, tc.weights))
+		})
+	}
+}
+
+// TestPartitionByLoad_Optimal 和暴力枚举所有切法比较，最大的 段请求数/段权重 必须是最优的
+func TestPartitionByLoad_Optimal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		loads := make([]int, 10)
+		for i := range loads {
+			loads[i] = r.Intn(100)
+		}
+		weights := []int{r.Intn(3) + 1, r.Intn(3) + 1, r.Intn(3) + 1}
+		prefixSum := prefixSums(loads)
+		totalW := weights[0] + weights[1] + weights[2]
+
+		got, _ := evaluate(prefixSum, weights, totalW, partitionByLoad(loads, weights))
+		best := -1.0
+		for i := 0; i <= len(loads); i++ {
+			for j := i; j <= len(loads); j++ {
+				m, _ := evaluate(prefixSum, weights, totalW, []int{i, j, len(loads)})
+				if best < 0 || m < best {
+					best = m
+				}
+			}
+		}
+		assert.InDelta(t, best, got, 1e-9, "loads %v, weights %v", loads, weights)
+	}
+}
+
+func BenchmarkPartitionByLoad(b *testing.B) {
+	for _, slotNum := range []int{1024, 16384, 65536} {
+		r := rand.New(rand.NewSource(1))
+		// 少数槽特别热，模拟真实的请求分布
+		zipf := rand.NewZipf(r, 1.1, 1, 10000)
+		loads := make([]int, slotNum)
+		for i := range loads {
+			loads[i] = int(zipf.Uint64())
+		}
+		weights := make([]int, 32)
+		for i := range weights {
+			weights[i] = r.Intn(4) + 1
+		}
+		b.Run(fmt.Sprintf("slots_%d", slotNum), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				partitionByLoad(loads, weights)
+			}
+		})
+	}
+}
+
+func BenchmarkHashRing_Balance(b *testing.B) {
+	for _, slotNum := range []int{1024, 16384, 65536} {
+		nodes := make([]*Node, 0, 32)
+		for i := 0; i < 32; i++ {
+			nodes = append(nodes, NewNode(fmt.Sprintf("node_%d", i), fmt.Sprintf("address_%d", i), i%4+1))
+		}
+		h := NewHashRing(nodes, slotNum, func(req any) int {
+			return req.(int) % slotNum
+		})
+		r := rand.New(rand.NewSource(1))
+		loads := make([]int, slotNum)
+		for i := range loads {
+			loads[i] = r.Intn(1000)
+		}
+		b.Run(fmt.Sprintf("slots_%d", slotNum), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h.SetRequestNumOfSlot(loads)
+				h.Balance()
+			}
+		})
+	}
+}
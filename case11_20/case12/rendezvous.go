@@ -0,0 +1,77 @@
+package case12
+
+import (
+	"math"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Rendezvous 最高随机权重（HRW）哈希：每个节点对 key 打分，分数最高的节点负责这个 key。
+// 节点变更的时候只有落在这个节点上的 key 会被重新映射，代价是每次路由都要遍历所有节点
+type Rendezvous struct {
+	lock  sync.RWMutex
+	nodes []*Node
+}
+
+func NewRendezvous(nodes []*Node) *Rendezvous {
+	return &Rendezvous{nodes: nodes}
+}
+
+func (r *Rendezvous) GetNodeByKey(key string) (*Node, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if len(r.nodes) == 0 {
+		return nil, ErrNoNode
+	}
+	tag := HashTag([]byte(key))
+	var (
+		res  *Node
+		best = math.Inf(-1)
+	)
+	for _, n := range r.nodes {
+		if score := rendezvousScore(tag, n); score > best {
+			res, best = n, score
+		}
+	}
+	return res, nil
+}
+
+// rendezvousScore 带权重的打分：w / -ln(u)，u 是 key 和节点一起哈希出来的 (0, 1) 之间的数
+func rendezvousScore(key []byte, n *Node) float64 {
+	d := xxhash.New()
+	_, _ = d.Write(key)
+	// 加一个分隔符，不然 ("ab", "c") 和 ("a", "bc") 的哈希是一样的
+	_, _ = d.Write([]byte{0})
+	_, _ = d.WriteString(n.name)
+	u := (float64(d.Sum64()>>11) + 0.5) / (1 << 53)
+	return float64(n.Weight()) / -math.Log(u)
+}
+
+func (r *Rendezvous) Join(node *Node) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	nodes, err := appendNode(r.nodes, node)
+	if err != nil {
+		return err
+	}
+	r.nodes = nodes
+	return nil
+}
+
+func (r *Rendezvous) Leave(name string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	nodes, err := deleteNode(r.nodes, name)
+	if err != nil {
+		return err
+	}
+	r.nodes = nodes
+	return nil
+}
+
+func (r *Rendezvous) Nodes() []*Node {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return append([]*Node(nil), r.nodes...)
+}
@@ -0,0 +1,167 @@
+package case12
+
+import (
+	"math/rand/v2"
+)
+
+// ReadPolicy 读的时候怎么在主节点和副本之间选择
+type ReadPolicy int
+
+const (
+	// ReadPrimary 只读主节点，主节点下线的时候读第一个可用的副本
+	ReadPrimary ReadPolicy = iota
+	// ReadAnyReplica 在可用的主节点和副本里面随机选一个，分摊读压力
+	ReadAnyReplica
+	// ReadNearest 优先选和调用方同一个机房的节点，没有的时候和 ReadPrimary 一样
+	ReadNearest
+)
+
+// ReadOptions GetNodeForRead 的选项
+type ReadOptions struct {
+	Policy ReadPolicy
+	// Zone 调用方所在的机房，ReadNearest 的时候用
+	Zone string
+}
+
+// SetReplicas 设置每个槽的副本数量。副本放在节点列表里面主节点后面的 n 个节点上，
+// 节点数量不够的时候副本会少一些。副本数量不属于路由表，每个进程需要自己设置。
+// 副本只影响路由，HashRing 和 Migrator 都不会把数据写到副本上，也不会在副本变化的时候复制数据，
+// 需要调用方用 GetReplicasByKey 自己写所有的副本，不然切换到副本之后缓存都是空的
+func (h *HashRing) SetReplicas(n int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.replicaNum = n
+	t := *h.table.Load()
+	h.storeTable(&t)
+}
+
+// MarkDown 把节点标记为下线，它负责的槽自动切换到第一个可用的副本上
+func (h *HashRing) MarkDown(name string) error {
+	return h.setDown(name, true)
+}
+
+// MarkUp 节点恢复，重新负责它的槽
+func (h *HashRing) MarkUp(name string) error {
+	return h.setDown(name, false)
+}
+
+func (h *HashRing) setDown(name string, down bool) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, n := range h.table.Load().slotOfNodes {
+		if n.name == name {
+			n.down.Store(down)
+			return nil
+		}
+	}
+	idx := h.findNode(name)
+	if idx < 0 {
+		return ErrNodeNotFound
+	}
+	h.nodes[idx].down.Store(down)
+	return nil
+}
+
+// GetReplicasByKey key 所在的槽的主节点和副本，不考虑节点是否下线。写的时候需要写到所有的副本上
+func (h *HashRing) GetReplicasByKey(key string) (primary *Node, replicas []*Node, err error) {
+	slot, err := h.slotOf(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	t := h.table.Load()
+	return t.slotOfNodes[slot], t.replicasOf(slot), nil
+}
+
+// GetNodeForRead 按照 opts 在 key 所在的槽的主节点和副本里面选一个可用的节点，
+// 全部下线的时候返回 ErrNoNode
+func (h *HashRing) GetNodeForRead(key string, opts ReadOptions) (*Node, error) {
+	slot, err := h.slotOf(key)
+	if err != nil {
+		return nil, err
+	}
+	h.countSlotRequest(slot)
+	t := h.table.Load()
+	candidates := make([]*Node, 0, h.replicaNum+1)
+	for _, n := range append([]*Node{t.slotOfNodes[slot]}, t.replicasOf(slot)...) {
+		if n.Available() {
+			candidates = append(candidates, n)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoNode
+	}
+	switch opts.Policy {
+	case ReadAnyReplica:
+		return candidates[rand.IntN(len(candidates))], nil
+	case ReadNearest:
+		for _, n := range candidates {
+			if n.zone == opts.Zone {
+				return n, nil
+			}
+		}
+	}
+	return candidates[0], nil
+}
+
+// primary 槽的主节点，主节点下线的时候是第一个可用的副本，都不可用的时候还是主节点
+func (t *routeTable) primary(slot int) *Node {
+	n := t.slotOfNodes[slot]
+	if n.Available() {
+		return n
+	}
+	for _, r := range t.replicasOf(slot) {
+		if r.Available() {
+			return r
+		}
+	}
+	return n
+}
+
+func (t *routeTable) replicasOf(slot int) []*Node {
+	if t.replicas == nil {
+		return nil
+	}
+	return t.replicas[slot]
+}
+
+// storeTable 计算副本之后换上新的路由表。调用方必须持有 h.lock
+func (h *HashRing) storeTable(t *routeTable) {
+	t.replicas = nil
+	if h.replicaNum > 0 {
+		t.replicas = make([][]*Node, len(t.slotOfNodes))
+		// 同一个主节点的槽共用同一组副本
+		cache := make(map[*Node][]*Node, h.nodeNum)
+		for slot, n := range t.slotOfNodes {
+			replicas, ok := cache[n]
+			if !ok {
+				replicas = h.placeReplicas(n)
+				cache[n] = replicas
+			}
+			t.replicas[slot] = replicas
+		}
+	}
+	h.table.Store(t)
+}
+
+// refreshReplicas 节点列表变化之后重新计算副本，路由表的版本不变。调用方必须持有 h.lock
+func (h *HashRing) refreshReplicas() {
+	if h.replicaNum == 0 {
+		return
+	}
+	t := *h.table.Load()
+	h.storeTable(&t)
+}
+
+// placeReplicas 从 primary 在节点列表里面的下一个节点开始，依次选 replicaNum 个不同的节点。
+// primary 已经被删除的时候从第一个节点开始
+func (h *HashRing) placeReplicas(primary *Node) []*Node {
+	start := h.findNode(primary.name) + 1
+	res := make([]*Node, 0, h.replicaNum)
+	for i := 0; i < h.nodeNum && len(res) < h.replicaNum; i++ {
+		n := h.nodes[(start+i)%h.nodeNum]
+		if n != primary {
+			res = append(res, n)
+		}
+	}
+	return res
+}
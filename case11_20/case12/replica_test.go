@@ -0,0 +1,154 @@
+package case12
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newReplicaRing(replicas int, names ...string) (*HashRing, []*Node) {
+	nodes := make([]*Node, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, NewNode(name, name+"_address", 1))
+	}
+	h := NewHashRing(nodes, 8, func(req any) int {
+		if uid, ok := req.(int); ok {
+			return uid % 8
+		}
+		return KeyHashCodeFunc(CRC16, 8)(req)
+	})
+	h.SetReplicas(replicas)
+	return h, nodes
+}
+
+func TestHashRing_Replicas(t *testing.T) {
+	h, nodes := newReplicaRing(2, "a", "b", "c", "d")
+	a, b, c, d := nodes[0], nodes[1], nodes[2], nodes[3]
+	testCases := []struct {
+		slot         int
+		wantPrimary  *Node
+		wantReplicas []*Node
+	}{
+		{slot: 0, wantPrimary: a, wantReplicas: []*Node{b, c}},
+		{slot: 3, wantPrimary: b, wantReplicas: []*Node{c, d}},
+		{slot: 7, wantPrimary: d, wantReplicas: []*Node{a, b}},
+	}
+	table := h.table.Load()
+	for _, tc := range testCases {
+		assert.Equal(t, tc.wantPrimary, table.slotOfNodes[tc.slot])
+		assert.Equal(t, tc.wantReplicas, table.replicasOf(tc.slot))
+	}
+
+	// 加入节点之后副本跟着变化，路由表的版本不变
+	epoch := h.Epoch()
+	_, err := h.AddNode(NewNode("e", "e_address", 1))
+	require.NoError(t, err)
+	assert.Equal(t, epoch, h.Epoch())
+	assert.Equal(t, "e", h.table.Load().replicasOf(7)[0].Name())
+
+	// 节点不够的时候副本少一些，副本不会和主节点重复
+	h, nodes = newReplicaRing(3, "a", "b")
+	assert.Equal(t, []*Node{nodes[1]}, h.table.Load().replicasOf(0))
+}
+
+func TestHashRing_Failover(t *testing.T) {
+	h, nodes := newReplicaRing(2, "a", "b", "c", "d")
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	assert.Equal(t, a, h.GetNode(0))
+
+	// a 下线，切换到第一个副本
+	require.NoError(t, h.MarkDown("a"))
+	assert.Equal(t, b, h.GetNode(0))
+	n, err := h.GetNodeForRead("", ReadOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, b, n)
+
+	require.NoError(t, h.MarkDown("b"))
+	assert.Equal(t, c, h.GetNode(0))
+
+	// 所有的副本都下线了
+	require.NoError(t, h.MarkDown("c"))
+	assert.Equal(t, a, h.GetNode(0))
+	_, err = h.GetNodeForRead("", ReadOptions{})
+	assert.Equal(t, ErrNoNode, err)
+
+	// a 恢复
+	require.NoError(t, h.MarkUp("a"))
+	assert.Equal(t, a, h.GetNode(0))
+	assert.Equal(t, ErrNodeNotFound, h.MarkDown("x"))
+}
+
+func TestHashRing_CheckOwnerFailover(t *testing.T) {
+	h, nodes := newReplicaRing(1, "a", "b", "c", "d")
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	// 找一个在 a 上面的 key，它的副本是 b
+	key := ""
+	for i := 0; ; i++ {
+		key = fmt.Sprintf("key_%d", i)
+		if n, err := h.GetNodeByKey(key); err == nil && n == a {
+			break
+		}
+	}
+	assert.NoError(t, h.CheckOwner(a, key))
+	// 可用的副本可以处理读请求
+	assert.NoError(t, h.CheckOwner(b, key))
+	var moved *MovedError
+	require.ErrorAs(t, h.CheckOwner(c, key), &moved)
+	assert.Equal(t, "a", moved.Node)
+
+	// a 下线，b 接管之后不会再让调用方回到 a
+	require.NoError(t, h.MarkDown("a"))
+	assert.NoError(t, h.CheckOwner(b, key))
+	require.ErrorAs(t, h.CheckOwner(a, key), &moved)
+	assert.Equal(t, "b", moved.Node)
+	require.ErrorAs(t, h.CheckOwner(c, key), &moved)
+	assert.Equal(t, "b", moved.Node)
+
+	// 副本也下线了，回到 a
+	require.NoError(t, h.MarkDown("b"))
+	require.ErrorAs(t, h.CheckOwner(b, key), &moved)
+	assert.Equal(t, "a", moved.Node)
+}
+
+func TestHashRing_GetNodeForRead(t *testing.T) {
+	h, nodes := newReplicaRing(2, "a", "b", "c", "d")
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	b.SetZone("bj")
+	c.SetZone("sh")
+	// 空字符串在 0 号槽
+	primary, replicas, err := h.GetReplicasByKey("")
+	require.NoError(t, err)
+	assert.Equal(t, a, primary)
+	assert.Equal(t, []*Node{b, c}, replicas)
+
+	testCases := []struct {
+		name string
+		opts ReadOptions
+		want *Node
+	}{
+		{name: "只读主节点", opts: ReadOptions{Policy: ReadPrimary, Zone: "sh"}, want: a},
+		{name: "同一个机房", opts: ReadOptions{Policy: ReadNearest, Zone: "sh"}, want: c},
+		{name: "没有同一个机房的节点", opts: ReadOptions{Policy: ReadNearest, Zone: "gz"}, want: a},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := h.GetNodeForRead("", tc.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, n)
+		})
+	}
+
+	// 随机读会分摊到主节点和所有可用的副本上
+	require.NoError(t, h.MarkDown("b"))
+	cnt := make(map[*Node]int)
+	for i := 0; i < 300; i++ {
+		n, err := h.GetNodeForRead("", ReadOptions{Policy: ReadAnyReplica})
+		require.NoError(t, err)
+		cnt[n]++
+	}
+	assert.Len(t, cnt, 2)
+	assert.Greater(t, cnt[a], 50)
+	assert.Greater(t, cnt[c], 50)
+}
@@ -0,0 +1,242 @@
+package case12
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrNoSnapshot = errors.New("还没有发布过路由表")
+
+// RouteStore 保存共享的路由表，RedisRouteStore 和 FileRouteStore 都实现了。
+// 版本由 RouteStore 分配，每次发布加一。同一个 RouteStore 只能有一个发布者，
+// 一般是执行 Join、Leave 和 Balance 的那个进程，其它进程只通过 SyncRoutes 同步；
+// 多个发布者的时候后发布的直接覆盖先发布的
+type RouteStore interface {
+	// Publish 发布新的路由表，分配的版本写回 s.Epoch
+	Publish(ctx context.Context, s *RouteSnapshot) error
+	// Load 读取最新的路由表，没有发布过的时候返回 ErrNoSnapshot
+	Load(ctx context.Context) (*RouteSnapshot, error)
+	// Watch 有版本大于 since 的路由表发布的时候调用 fn，会一直阻塞到 ctx 被取消。
+	// since 一般是 Load 读到的版本，Load 和 Watch 之间发布的路由表不会漏掉
+	Watch(ctx context.Context, since uint64, fn func(s *RouteSnapshot)) error
+}
+
+var (
+	_ RouteStore = (*RedisRouteStore)(nil)
+	_ RouteStore = (*FileRouteStore)(nil)
+)
+
+// PublishRoutes 把 h 当前的路由表发布到 store，返回 store 分配的版本。
+// 发布者自己再收到这个版本的时候会忽略
+func PublishRoutes(ctx context.Context, h *HashRing, store RouteStore) (uint64, error) {
+	s := h.Snapshot()
+	if err := store.Publish(ctx, s); err != nil {
+		return 0, err
+	}
+	h.markPublished(s.Epoch)
+	return s.Epoch, nil
+}
+
+// SyncRoutes 先加载最新的路由表，然后监听新发布的路由表，一直阻塞到 ctx 被取消。
+// 收到的路由表比上一次应用的旧的时候忽略
+func SyncRoutes(ctx context.Context, h *HashRing, store RouteStore) error {
+	apply := func(s *RouteSnapshot) {
+		err := h.ApplySnapshot(s)
+		switch {
+		case errors.Is(err, ErrStaleSnapshot):
+			slog.Info("忽略旧的路由表", slog.Uint64("epoch", s.Epoch), slog.Any("err", err))
+		case err != nil:
+			slog.Error("应用路由表失败", slog.Uint64("epoch", s.Epoch), slog.Any("err", err))
+		}
+	}
+	var since uint64
+	s, err := store.Load(ctx)
+	switch {
+	case err == nil:
+		apply(s)
+		since = s.Epoch
+	case !errors.Is(err, ErrNoSnapshot):
+		return err
+	}
+	return store.Watch(ctx, since, apply)
+}
+
+// publishScript 分配下一个版本，和路由表一起写入，然后通知所有的客户端
+var publishScript = redis.NewScript(`
+local epoch = redis.call('HINCRBY', KEYS[1], 'epoch', 1)
+redis.call('HSET', KEYS[1], 'data', ARGV[1])
+redis.call('PUBLISH', KEYS[1], epoch)
+return epoch
+`)
+
+// RedisRouteStore 路由表保存在 redis 的 hash 里面，版本单独保存在 epoch 字段，
+// 发布的时候往和 key 同名的频道发通知
+type RedisRouteStore struct {
+	client redis.UniversalClient
+	key    string
+}
+
+func NewRedisRouteStore(client redis.UniversalClient, key string) *RedisRouteStore {
+	return &RedisRouteStore{client: client, key: key}
+}
+
+func (r *RedisRouteStore) Publish(ctx context.Context, s *RouteSnapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	epoch, err := publishScript.Run(ctx, r.client, []string{r.key}, data).Uint64()
+	if err != nil {
+		return err
+	}
+	s.Epoch = epoch
+	return nil
+}
+
+func (r *RedisRouteStore) Load(ctx context.Context) (*RouteSnapshot, error) {
+	vals, err := r.client.HMGet(ctx, r.key, "epoch", "data").Result()
+	if err != nil {
+		return nil, err
+	}
+	epoch, ok1 := vals[0].(string)
+	data, ok2 := vals[1].(string)
+	if !ok1 || !ok2 {
+		return nil, ErrNoSnapshot
+	}
+	var s RouteSnapshot
+	if err = json.Unmarshal([]byte(data), &s); err != nil {
+		return nil, err
+	}
+	// 以 redis 分配的版本为准
+	s.Epoch, err = strconv.ParseUint(epoch, 10, 64)
+	return &s, err
+}
+
+func (r *RedisRouteStore) Watch(ctx context.Context, since uint64, fn func(s *RouteSnapshot)) error {
+	pubsub := r.client.Subscribe(ctx, r.key)
+	defer pubsub.Close()
+	// 确认订阅成功，避免漏掉刚发出来的通知
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	epoch := since
+	check := func() {
+		// 通知里面只有版本，连续发布的时候直接读最新的，已经回调过的版本跳过
+		s, err := r.Load(ctx)
+		if err != nil {
+			if !errors.Is(err, ErrNoSnapshot) {
+				slog.Error("读取路由表失败", slog.Any("err", err))
+			}
+			return
+		}
+		if s.Epoch > epoch {
+			epoch = s.Epoch
+			fn(s)
+		}
+	}
+	// 订阅之前发布的路由表没有通知
+	check()
+	ch := pubsub.Channel()
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			check()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// FileRouteStore 路由表保存在文件里面，Watch 定时检查文件有没有变化，适合用配置中心下发文件的场景
+type FileRouteStore struct {
+	path     string
+	interval time.Duration
+}
+
+func NewFileRouteStore(path string, interval time.Duration) *FileRouteStore {
+	return &FileRouteStore{path: path, interval: interval}
+}
+
+// Publish 在文件里面的版本上加一。不同进程同时发布的时候没有保护，会分配到同一个版本
+func (f *FileRouteStore) Publish(ctx context.Context, s *RouteSnapshot) error {
+	var epoch uint64
+	cur, err := f.Load(ctx)
+	switch {
+	case err == nil:
+		epoch = cur.Epoch
+	case !errors.Is(err, ErrNoSnapshot):
+		return err
+	}
+	ns := *s
+	ns.Epoch = epoch + 1
+	data, err := json.Marshal(&ns)
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再改名，读的时候不会读到写了一半的数据
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".route-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+	s.Epoch = ns.Epoch
+	return nil
+}
+
+func (f *FileRouteStore) Load(_ context.Context) (*RouteSnapshot, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoSnapshot
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s RouteSnapshot
+	err = json.Unmarshal(data, &s)
+	return &s, err
+}
+
+func (f *FileRouteStore) Watch(ctx context.Context, since uint64, fn func(s *RouteSnapshot)) error {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	epoch := since
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s, err := f.Load(ctx)
+		if err != nil {
+			if !errors.Is(err, ErrNoSnapshot) {
+				slog.Error("读取路由表失败", slog.Any("err", err))
+			}
+			continue
+		}
+		if s.Epoch > epoch {
+			epoch = s.Epoch
+			fn(s)
+		}
+	}
+}
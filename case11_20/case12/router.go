@@ -0,0 +1,78 @@
+package case12
+
+import "errors"
+
+var ErrNoNode = errors.New("没有可用的节点")
+
+// Router 把 key 路由到节点上，HashRing、Ketama、JumpHash、Rendezvous 和 BoundedLoad 都实现了，
+// 方便比较不同的分片算法
+type Router interface {
+	// GetNodeByKey 返回负责 key 的节点
+	GetNodeByKey(key string) (*Node, error)
+	// Join 加入一个新节点
+	Join(node *Node) error
+	// Leave 删除节点
+	Leave(name string) error
+	// Nodes 当前所有的节点
+	Nodes() []*Node
+}
+
+var (
+	_ Router = (*HashRing)(nil)
+	_ Router = (*Ketama)(nil)
+	_ Router = (*JumpHash)(nil)
+	_ Router = (*Rendezvous)(nil)
+	_ Router = (*BoundedLoad)(nil)
+)
+
+// Join 加入节点并且立刻切换路由，不搬迁数据。需要搬迁数据的时候用 AddNode
+func (h *HashRing) Join(node *Node) error {
+	plan, err := h.AddNode(node)
+	if err != nil {
+		return err
+	}
+	return h.ApplyPlan(plan)
+}
+
+// Leave 删除节点并且立刻切换路由，不搬迁数据。需要搬迁数据的时候用 RemoveNode
+func (h *HashRing) Leave(name string) error {
+	plan, err := h.RemoveNode(name)
+	if err != nil {
+		return err
+	}
+	return h.ApplyPlan(plan)
+}
+
+func (h *HashRing) Nodes() []*Node {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]*Node(nil), h.nodes...)
+}
+
+// appendNode 返回加入 node 之后的节点列表，不修改 nodes
+func appendNode(nodes []*Node, node *Node) ([]*Node, error) {
+	for _, n := range nodes {
+		if n.name == node.name {
+			return nil, ErrNodeExists
+		}
+	}
+	res := make([]*Node, 0, len(nodes)+1)
+	res = append(res, nodes...)
+	return append(res, node), nil
+}
+
+// deleteNode 返回删除 name 之后的节点列表，不修改 nodes
+func deleteNode(nodes []*Node, name string) ([]*Node, error) {
+	for i, n := range nodes {
+		if n.name != name {
+			continue
+		}
+		if len(nodes) == 1 {
+			return nil, ErrLastNode
+		}
+		res := make([]*Node, 0, len(nodes)-1)
+		res = append(res, nodes[:i]...)
+		return append(res, nodes[i+1:]...), nil
+	}
+	return nil, ErrNodeNotFound
+}
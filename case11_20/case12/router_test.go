@@ -0,0 +1,219 @@
+package case12
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRouter_Compare 比较不同分片算法的负载偏差和节点变更时重新映射的 key 的比例
+func TestRouter_Compare(t *testing.T) {
+	const nodeNum, keyNum = 10, 100000
+	testCases := []struct {
+		name      string
+		newRouter func(nodes []*Node) Router
+		// 最重的节点的负载和平均值的比值的上限
+		maxSkew float64
+		// 加入一个节点之后重新映射的 key 的比例的上限，理想值是 1/11
+		maxAddRemapped float64
+		// 删除中间的一个节点之后重新映射的 key 的比例的上限，理想值是 1/10
+		maxRemoveRemapped float64
+	}{
+		{
+			name: "HashRing",
+			newRouter: func(nodes []*Node) Router {
+				return NewHashRing(nodes, RedisClusterSlotNum, KeyHashCodeFunc(CRC16, RedisClusterSlotNum))
+			},
+			maxSkew:           1.05,
+			maxAddRemapped:    0.1,
+			maxRemoveRemapped: 0.11,
+		},
+		{
+			name: "Ketama",
+			newRouter: func(nodes []*Node) Router {
+				return NewKetama(nodes)
+			},
+			maxSkew:           1.15,
+			maxAddRemapped:    0.11,
+			maxRemoveRemapped: 0.12,
+		},
+		{
+			name: "JumpHash",
+			newRouter: func(nodes []*Node) Router {
+				return NewJumpHash(nodes)
+			},
+			maxSkew:        1.05,
+			maxAddRemapped: 0.1,
+			// 删除中间的节点，后面的节点全部错位
+			maxRemoveRemapped: 1,
+		},
+		{
+			name: "Rendezvous",
+			newRouter: func(nodes []*Node) Router {
+				return NewRendezvous(nodes)
+			},
+			maxSkew:           1.05,
+			maxAddRemapped:    0.1,
+			maxRemoveRemapped: 0.11,
+		},
+		{
+			name: "BoundedLoad",
+			newRouter: func(nodes []*Node) Router {
+				return mustBoundedLoad(t, nodes, 1.05)
+			},
+			maxSkew: 1.05,
+			// 负载满了的 key 会溢出到其它节点，节点变更之后溢出的位置也会变化
+			maxAddRemapped:    0.15,
+			maxRemoveRemapped: 0.15,
+		},
+	}
+	keys := make([]string, keyNum)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key_%d", i)
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nodes := make([]*Node, 0, nodeNum)
+			for i := 0; i < nodeNum; i++ {
+				nodes = append(nodes, NewNode(fmt.Sprintf("node_%d", i), fmt.Sprintf("address_%d", i), 1))
+			}
+			r := tc.newRouter(nodes)
+
+			before := routeKeys(t, r, keys)
+			skew := loadSkew(before, nodeNum)
+
+			require.NoError(t, r.Join(NewNode("node_new", "address_new", 1)))
+			added := routeKeys(t, r, keys)
+			addRemapped := remapped(before, added)
+
+			require.NoError(t, r.Leave("node_3"))
+			removed := routeKeys(t, r, keys)
+			removeRemapped := remapped(added, removed)
+
+			t.Logf("负载偏差 %.3f，加入节点重新映射 %.3f，删除节点重新映射 %.3f", skew, addRemapped, removeRemapped)
+			assert.LessOrEqual(t, skew, tc.maxSkew)
+			assert.LessOrEqual(t, addRemapped, tc.maxAddRemapped)
+			assert.LessOrEqual(t, removeRemapped, tc.maxRemoveRemapped)
+		})
+	}
+}
+
+func TestRouter_Membership(t *testing.T) {
+	routers := map[string]func(nodes []*Node) Router{
+		"HashRing": func(nodes []*Node) Router {
+			return NewHashRing(nodes, 16, KeyHashCodeFunc(CRC16, 16))
+		},
+		"Ketama":      func(nodes []*Node) Router { return NewKetama(nodes) },
+		"JumpHash":    func(nodes []*Node) Router { return NewJumpHash(nodes) },
+		"Rendezvous":  func(nodes []*Node) Router { return NewRendezvous(nodes) },
+		"BoundedLoad": func(nodes []*Node) Router { return mustBoundedLoad(t, nodes, 1.25) },
+	}
+	for name, newRouter := range routers {
+		t.Run(name, func(t *testing.T) {
+			a, b := NewNode("a", "a_address", 1), NewNode("b", "b_address", 1)
+			r := newRouter([]*Node{a})
+			assert.Equal(t, ErrNodeExists, r.Join(NewNode("a", "a_address", 1)))
+			assert.Equal(t, ErrLastNode, r.Leave("a"))
+			assert.Equal(t, ErrNodeNotFound, r.Leave("b"))
+
+			require.NoError(t, r.Join(b))
+			assert.Equal(t, []*Node{a, b}, r.Nodes())
+			require.NoError(t, r.Leave("a"))
+			assert.Equal(t, []*Node{b}, r.Nodes())
+			n, err := r.GetNodeByKey("foo")
+			require.NoError(t, err)
+			assert.Equal(t, b, n)
+		})
+	}
+}
+
+func routeKeys(t *testing.T, r Router, keys []string) map[string]*Node {
+	res := make(map[string]*Node, len(keys))
+	for _, key := range keys {
+		n, err := r.GetNodeByKey(key)
+		require.NoError(t, err)
+		res[key] = n
+	}
+	return res
+}
+
+func loadSkew(routes map[string]*Node, nodeNum int) float64 {
+	loads := make(map[*Node]int, nodeNum)
+	maxLoad := 0
+	for _, n := range routes {
+		loads[n]++
+		maxLoad = max(maxLoad, loads[n])
+	}
+	return float64(maxLoad) / (float64(len(routes)) / float64(nodeNum))
+}
+
+func remapped(before, after map[string]*Node) float64 {
+	cnt := 0
+	for key, n := range before {
+		if after[key] != n {
+			cnt++
+		}
+	}
+	return float64(cnt) / float64(len(before))
+}
+
+func mustBoundedLoad(t *testing.T, nodes []*Node, factor float64) *BoundedLoad {
+	b, err := NewBoundedLoad(nodes, factor)
+	require.NoError(t, err)
+	return b
+}
+
+// TestBoundedLoad_Stable 负载不变的时候，同一个 key 一直落在同一个节点上
+func TestBoundedLoad_Stable(t *testing.T) {
+	nodes := []*Node{NewNode("a", "a_address", 1), NewNode("b", "b_address", 1), NewNode("c", "c_address", 1)}
+	b := mustBoundedLoad(t, nodes, 1.1)
+	keys := make([]string, 300)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key_%d", i)
+	}
+	want := routeKeys(t, b, keys)
+	// 热点 key 被反复访问，不会把自己或者别的 key 挤到其它节点上
+	for i := 0; i < 1000; i++ {
+		_, err := b.GetNodeByKey(keys[0])
+		require.NoError(t, err)
+	}
+	assert.Equal(t, want, routeKeys(t, b, keys))
+
+	// 释放之后负载减少，新的 key 可以分配到原来满了的节点上
+	owner := want[keys[1]]
+	for key, n := range want {
+		if n == owner {
+			b.Release(key)
+		}
+	}
+	b.Release("unknown")
+	n, err := b.GetNodeByKey(keys[1])
+	require.NoError(t, err)
+	assert.Equal(t, owner, n)
+	assert.Equal(t, 1, b.loads[owner])
+}
+
+func TestNewBoundedLoad(t *testing.T) {
+	nodes := []*Node{NewNode("a", "a_address", 1), NewNode("b", "b_address", 1)}
+	for _, factor := range []float64{0, 0.5, 1, math.NaN()} {
+		_, err := NewBoundedLoad(nodes, factor)
+		assert.ErrorIs(t, err, ErrInvalidBoundedLoadFactor)
+	}
+	b, err := NewBoundedLoad(nodes, 1.01)
+	require.NoError(t, err)
+	// 负载很不均匀的时候也总能找到节点
+	for i := 0; i < 1000; i++ {
+		_, err = b.GetNodeByKey("hot")
+		require.NoError(t, err)
+	}
+}
+
+func TestRendezvousScore_Separator(t *testing.T) {
+	// key 和节点名字拼起来一样，分数也不能一样
+	assert.NotEqual(t,
+		rendezvousScore([]byte("ab"), NewNode("c", "c_address", 1)),
+		rendezvousScore([]byte("a"), NewNode("bc", "bc_address", 1)))
+}
@@ -0,0 +1,283 @@
+package case12
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrStaleSnapshot    = errors.New("路由表的版本不比当前的新")
+	ErrSnapshotMismatch = errors.New("路由表和当前的 HashRing 不匹配")
+)
+
+// RouteSnapshot 序列化之后的路由表，发布到共享的存储里面，所有的客户端用同一份路由表
+type RouteSnapshot struct {
+	// Epoch Snapshot 返回的是本地的版本，发布之后换成 RouteStore 分配的版本
+	Epoch   uint64         `json:"epoch"`
+	SlotNum int            `json:"slot_num"`
+	Nodes   []NodeSnapshot `json:"nodes"`
+	// Ranges 按照槽的顺序排列，覆盖所有的槽
+	Ranges []RangeSnapshot `json:"ranges"`
+}
+
+type NodeSnapshot struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Weight  int    `json:"weight"`
+	// Removed 节点已经删除，但是还有槽没有迁移完
+	Removed bool `json:"removed,omitempty"`
+}
+
+// RangeSnapshot 一段连续的、归属相同的槽，Fallback 不为空表示这一段正在从 Fallback 迁移到 Node
+type RangeSnapshot struct {
+	SlotRange
+	Node     string `json:"node"`
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// Epoch 本地路由表的版本，每次变化都加一，不能和别的进程的比较
+func (h *HashRing) Epoch() uint64 {
+	return h.table.Load().epoch
+}
+
+// StoreEpoch 最后一次应用或者发布的路由表在 RouteStore 里面的版本，没有用过 RouteStore 的时候是 0
+func (h *HashRing) StoreEpoch() uint64 {
+	return h.storeEpoch.Load()
+}
+
+// Snapshot 当前的路由表
+func (h *HashRing) Snapshot() *RouteSnapshot {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	t := h.table.Load()
+	res := &RouteSnapshot{Epoch: t.epoch, SlotNum: h.slotNum}
+	for i, n := range h.routeNodes(t) {
+		res.Nodes = append(res.Nodes, NodeSnapshot{Name: n.name, Address: n.address, Weight: n.Weight(),
+			Removed: i >= len(h.nodes)})
+	}
+	for slot, n := range t.slotOfNodes {
+		var fallback *Node
+		if t.fallback != nil {
+			fallback = t.fallback[slot]
+		}
+		cnt := len(res.Ranges)
+		if cnt > 0 {
+			last := &res.Ranges[cnt-1]
+			if last.Node == n.name && last.Fallback == nodeName(fallback) {
+				last.End++
+				continue
+			}
+		}
+		res.Ranges = append(res.Ranges, RangeSnapshot{
+			SlotRange: SlotRange{Start: slot, End: slot + 1},
+			Node:      n.name,
+			Fallback:  nodeName(fallback),
+		})
+	}
+	return res
+}
+
+// RouteNodes 路由表里面的所有节点。已经删除但是还有槽没有迁移完的节点也在里面，排在最后
+func (h *HashRing) RouteNodes() []*Node {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.routeNodes(h.table.Load())
+}
+
+// routeNodes 先是 h.nodes，然后是 t 里面还有槽的、已经删除的节点，调用方需要持有 lock
+func (h *HashRing) routeNodes(t *routeTable) []*Node {
+	res := append([]*Node(nil), h.nodes...)
+	seen := make(map[*Node]bool, len(res))
+	for _, n := range res {
+		seen[n] = true
+	}
+	add := func(n *Node) {
+		if n == nil || seen[n] {
+			return
+		}
+		seen[n] = true
+		res = append(res, n)
+	}
+	for slot, n := range t.slotOfNodes {
+		add(n)
+		if t.fallback != nil {
+			add(t.fallback[slot])
+		}
+	}
+	return res
+}
+
+// ApplySnapshot 换上别的进程发布的路由表，StoreEpoch 换成 s.Epoch，本地的版本照常加一。
+// s.Epoch 不比 StoreEpoch 新的时候返回 ErrStaleSnapshot，本地自己的变更不参与比较。
+// 已经有的节点保持不变，新出现的节点用 NewNode 创建，可以通过 SetNodeFactory 修改
+func (h *HashRing) ApplySnapshot(s *RouteSnapshot) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	t := h.table.Load()
+	if cur := h.storeEpoch.Load(); s.Epoch <= cur {
+		return fmt.Errorf("%w: 版本 %d，已经应用过 %d", ErrStaleSnapshot, s.Epoch, cur)
+	}
+	if s.SlotNum != h.slotNum {
+		return fmt.Errorf("%w: 槽的数量是 %d，当前是 %d", ErrSnapshotMismatch, s.SlotNum, h.slotNum)
+	}
+
+	existing := make(map[string]*Node, h.nodeNum)
+	for _, n := range t.slotOfNodes {
+		existing[n.name] = n
+	}
+	for _, n := range h.nodes {
+		existing[n.name] = n
+	}
+	for name, n := range h.redirected {
+		if _, ok := existing[name]; !ok {
+			existing[name] = n
+		}
+	}
+	nodes := make(map[string]*Node, len(s.Nodes))
+	for _, ns := range s.Nodes {
+		n, ok := existing[ns.Name]
+		if !ok {
+			n = h.newNode(ns.Name, ns.Address, ns.Weight)
+		}
+		nodes[ns.Name] = n
+	}
+
+	nt := &routeTable{epoch: t.epoch + 1, slotOfNodes: make([]*Node, 0, h.slotNum)}
+	for _, r := range s.Ranges {
+		n, ok := nodes[r.Node]
+		if !ok || r.Start != len(nt.slotOfNodes) || r.End <= r.Start || r.End > h.slotNum {
+			return fmt.Errorf("%w: 槽 [%d, %d) 不合法", ErrSnapshotMismatch, r.Start, r.End)
+		}
+		for slot := r.Start; slot < r.End; slot++ {
+			nt.slotOfNodes = append(nt.slotOfNodes, n)
+		}
+		if r.Fallback == "" {
+			continue
+		}
+		fallback, ok := nodes[r.Fallback]
+		if !ok {
+			return fmt.Errorf("%w: 节点 %s 不存在", ErrSnapshotMismatch, r.Fallback)
+		}
+		if nt.fallback == nil {
+			nt.fallback = make([]*Node, h.slotNum)
+		}
+		for slot := r.Start; slot < r.End; slot++ {
+			nt.fallback[slot] = fallback
+		}
+	}
+	if len(nt.slotOfNodes) != h.slotNum {
+		return fmt.Errorf("%w: 只覆盖了 %d 个槽", ErrSnapshotMismatch, len(nt.slotOfNodes))
+	}
+
+	// 已经删除的节点只出现在路由表里面
+	h.nodes = make([]*Node, 0, len(s.Nodes))
+	for _, ns := range s.Nodes {
+		n := nodes[ns.Name]
+		n.weight.Store(int64(ns.Weight))
+		if !ns.Removed {
+			h.nodes = append(h.nodes, n)
+		}
+	}
+	h.nodeNum = len(h.nodes)
+	// 路由表里面已经有了，不用再单独保存
+	h.redirected = nil
+	// 本地还没有开始的计划是基于旧的路由表的，已经没有意义了
+	h.reserved, h.reservedNum = nil, 0
+	h.storeEpoch.Store(s.Epoch)
+	h.storeTable(nt)
+	return nil
+}
+
+// markPublished 本地的路由表已经以 epoch 发布了，之后收到同一个版本不用再应用
+func (h *HashRing) markPublished(epoch uint64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if epoch > h.storeEpoch.Load() {
+		h.storeEpoch.Store(epoch)
+	}
+}
+
+// SetNodeFactory ApplySnapshot 遇到新节点的时候用 factory 创建，比如给新节点配上 redis 缓存
+func (h *HashRing) SetNodeFactory(factory func(name, address string, weight int) *Node) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.nodeFactory = factory
+}
+
+func (h *HashRing) newNode(name, address string, weight int) *Node {
+	if h.nodeFactory != nil {
+		return h.nodeFactory(name, address, weight)
+	}
+	return NewNode(name, address, weight)
+}
+
+func nodeName(n *Node) string {
+	if n == nil {
+		return ""
+	}
+	return n.name
+}
+
+// MovedError 节点收到了不归它负责的槽的请求，和 Redis Cluster 的 MOVED 一样告诉调用方应该去哪个节点
+type MovedError struct {
+	Slot int
+	// Epoch 节点的 StoreEpoch，调用方的 StoreEpoch 比它小说明本地的路由表落后了
+	Epoch   uint64
+	Node    string
+	Address string
+}
+
+func (e *MovedError) Error() string {
+	return fmt.Sprintf("MOVED %d %s %s epoch %d", e.Slot, e.Node, e.Address, e.Epoch)
+}
+
+// CheckOwner 节点收到请求的时候调用，key 所在的槽不归 node 负责的时候返回 *MovedError。
+// 主节点下线之后接管的副本，以及 GetNodeForRead 选中的可用副本都算负责这个槽，
+// MovedError 指向 GetNode 返回的节点。
+// 槽正在迁出的时候，旧节点依旧可以处理读请求，这里当作不归它负责
+func (h *HashRing) CheckOwner(node *Node, key string) error {
+	slot, err := h.slotOf(key)
+	if err != nil {
+		return err
+	}
+	t := h.table.Load()
+	owner := t.primary(slot)
+	if owner.name == node.name {
+		return nil
+	}
+	for _, r := range t.replicasOf(slot) {
+		if r.name == node.name && r.Available() {
+			return nil
+		}
+	}
+	return &MovedError{Slot: slot, Epoch: h.storeEpoch.Load(), Node: owner.name, Address: owner.address}
+}
+
+// Redirect 客户端收到 *MovedError 的时候调用，返回应该重试的节点。
+// 本地没有这个节点的时候创建一个并且缓存起来，ApplySnapshot 的时候也会复用。
+// 不会修改本地的路由表，本地的路由表由 SyncRoutes 更新，StoreEpoch 追上 MovedError.Epoch 之后路由就和节点一致了
+func (h *HashRing) Redirect(err error) (*Node, bool) {
+	var moved *MovedError
+	if !errors.As(err, &moved) {
+		return nil, false
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if idx := h.findNode(moved.Node); idx >= 0 {
+		return h.nodes[idx], true
+	}
+	for _, n := range h.table.Load().slotOfNodes {
+		if n.name == moved.Node {
+			return n, true
+		}
+	}
+	if n, ok := h.redirected[moved.Node]; ok {
+		return n, true
+	}
+	n := h.newNode(moved.Node, moved.Address, DefaultNodeWeight)
+	if h.redirected == nil {
+		h.redirected = make(map[string]*Node)
+	}
+	h.redirected[moved.Node] = n
+	return n, true
+}
@@ -0,0 +1,321 @@
+package case12
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"interview-cases/test"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashRing_ApplySnapshot(t *testing.T) {
+	leader, nodes := newTestRing(12, "a", "b", "c")
+	follower, _ := newTestRing(12, "a", "b", "c")
+	assert.Equal(t, uint64(1), leader.Epoch())
+
+	// Balance 之后版本加一
+	leader.SetRequestNumOfSlot([]int{100, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10})
+	require.NotZero(t, leader.Balance().MovedSlots())
+	assert.Equal(t, uint64(2), leader.Epoch())
+	applySnapshot(t, follower, leader.Snapshot())
+	assertSameRoutes(t, leader, follower)
+	assert.Equal(t, leader.Epoch(), follower.StoreEpoch())
+
+	// 同一个版本不会重复应用
+	assert.ErrorIs(t, follower.ApplySnapshot(leader.Snapshot()), ErrStaleSnapshot)
+
+	// 迁移到一半的路由表，新节点和回退都会同步过去
+	d := NewNode("d", "d_address", 1)
+	plan, err := leader.AddNode(d)
+	require.NoError(t, err)
+	require.NoError(t, leader.BeginMigration(plan.Migrations[0]))
+	_, err = leader.RemoveNode("a")
+	require.NoError(t, err)
+	s := leader.Snapshot()
+	assert.Equal(t, NodeSnapshot{Name: "a", Address: "a_address", Weight: 1, Removed: true}, s.Nodes[len(s.Nodes)-1])
+	applySnapshot(t, follower, s)
+	assertSameRoutes(t, leader, follower)
+	var names []string
+	for _, n := range follower.Nodes() {
+		names = append(names, n.Name())
+	}
+	assert.Equal(t, []string{"b", "c", "d"}, names)
+	// 迁移没有完成，不能 Balance
+	follower.SetRequestNumOfSlot([]int{100, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10})
+	assert.Equal(t, 0, follower.Balance().MovedSlots())
+
+	// 已经有的节点不会重新创建
+	n := follower.GetNode(11)
+	assert.Equal(t, "c", n.Name())
+	assert.NotSame(t, nodes[2], n)
+}
+
+// TestHashRing_ApplySnapshotMigrating 相邻的两次迁移在路由表里面合并成一段，完成一次之后另一次还在迁移
+func TestHashRing_ApplySnapshotMigrating(t *testing.T) {
+	leader, nodes := newTestRing(12, "a", "b", "c")
+	follower, _ := newTestRing(12, "a", "b", "c")
+	first := Migration{SlotRange: SlotRange{Start: 0, End: 2}, From: nodes[0], To: nodes[1]}
+	second := Migration{SlotRange: SlotRange{Start: 2, End: 4}, From: nodes[0], To: nodes[1]}
+	require.NoError(t, leader.BeginMigration(first))
+	require.NoError(t, leader.BeginMigration(second))
+	s := leader.Snapshot()
+	require.Len(t, s.Ranges, 3)
+	applySnapshot(t, follower, s)
+
+	// follower 上的节点是自己的，不是 leader 的
+	to, from := follower.GetNodeWithFallback(0)
+	require.Equal(t, "a", nodeName(from))
+	first.From, first.To = from, to
+	second.From, second.To = from, to
+	require.NoError(t, follower.CompleteMigration(first))
+	_, fallback := follower.GetNodeWithFallback(2)
+	assert.Equal(t, "a", nodeName(fallback))
+	// 还有迁移没有完成，不能 Balance
+	follower.SetRequestNumOfSlot([]int{100, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10})
+	assert.Equal(t, 0, follower.Balance().MovedSlots())
+
+	require.NoError(t, follower.CompleteMigration(second))
+	assert.Nil(t, follower.table.Load().fallback)
+}
+
+func TestHashRing_ApplySnapshotMismatch(t *testing.T) {
+	h, _ := newTestRing(4, "a", "b")
+	testCases := []struct {
+		name string
+		s    *RouteSnapshot
+	}{
+		{
+			name: "槽的数量不一样",
+			s:    &RouteSnapshot{Epoch: 2, SlotNum: 8},
+		},
+		{
+			name: "没有覆盖所有的槽",
+			s: &RouteSnapshot{Epoch: 2, SlotNum: 4,
+				Nodes:  []NodeSnapshot{{Name: "a"}},
+				Ranges: []RangeSnapshot{{SlotRange: SlotRange{Start: 0, End: 3}, Node: "a"}},
+			},
+		},
+		{
+			name: "节点不存在",
+			s: &RouteSnapshot{Epoch: 2, SlotNum: 4,
+				Nodes:  []NodeSnapshot{{Name: "a"}},
+				Ranges: []RangeSnapshot{{SlotRange: SlotRange{Start: 0, End: 4}, Node: "b"}},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ErrorIs(t, h.ApplySnapshot(tc.s), ErrSnapshotMismatch)
+			assert.Equal(t, uint64(1), h.Epoch())
+		})
+	}
+}
+
+func TestHashRing_Moved(t *testing.T) {
+	leader := NewHashRing([]*Node{NewNode("a", "a_address", 1), NewNode("b", "b_address", 1)},
+		16, KeyHashCodeFunc(CRC16, 16))
+	client := NewHashRing([]*Node{NewNode("a", "a_address", 1), NewNode("b", "b_address", 1)},
+		16, KeyHashCodeFunc(CRC16, 16))
+	// foo 在 6 号槽，原本在 a 上面，加入 c 之后迁移到 c
+	require.NoError(t, leader.Join(NewNode("c", "c_address", 1)))
+
+	n, err := client.GetNodeByKey("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "a", n.Name())
+	// a 收到请求，发现 foo 已经不归它负责了
+	err = leader.CheckOwner(n, "foo")
+	assert.Equal(t, &MovedError{Slot: 6, Epoch: leader.StoreEpoch(), Node: "c", Address: "c_address"}, err)
+	assert.Equal(t, "MOVED 6 c c_address epoch 0", err.Error())
+
+	n, ok := client.Redirect(err)
+	require.True(t, ok)
+	assert.Equal(t, "c", n.Name())
+	assert.NoError(t, leader.CheckOwner(n, "foo"))
+	_, ok = client.Redirect(ErrNodeNotFound)
+	assert.False(t, ok)
+
+	// 同一个节点只创建一次，同步路由表的时候也用它
+	n2, ok := client.Redirect(err)
+	require.True(t, ok)
+	assert.Same(t, n, n2)
+	applySnapshot(t, client, leader.Snapshot())
+	owner, err := client.GetNodeByKey("foo")
+	require.NoError(t, err)
+	assert.Same(t, n, owner)
+}
+
+// TestHashRing_MovedStoreEpoch MovedError 带的是 store 的版本，本地的变更不会改变它
+func TestHashRing_MovedStoreEpoch(t *testing.T) {
+	store := NewFileRouteStore(t.TempDir()+"/route.json", time.Second)
+	leader := NewHashRing([]*Node{NewNode("a", "a_address", 1), NewNode("b", "b_address", 1)},
+		16, KeyHashCodeFunc(CRC16, 16))
+	require.NoError(t, leader.Join(NewNode("c", "c_address", 1)))
+	epoch, err := PublishRoutes(context.Background(), leader, store)
+	require.NoError(t, err)
+	assert.Equal(t, epoch, leader.StoreEpoch())
+
+	a := NewNode("a", "a_address", 1)
+	var moved *MovedError
+	require.ErrorAs(t, leader.CheckOwner(a, "foo"), &moved)
+	assert.Equal(t, epoch, moved.Epoch)
+
+	local := leader.Epoch()
+	require.NoError(t, leader.Leave("b"))
+	assert.Greater(t, leader.Epoch(), local)
+	require.ErrorAs(t, leader.CheckOwner(a, "foo"), &moved)
+	assert.Equal(t, epoch, moved.Epoch)
+}
+
+func TestRouteStore(t *testing.T) {
+	testCases := []struct {
+		name     string
+		newStore func(t *testing.T) RouteStore
+	}{
+		{
+			name: "文件",
+			newStore: func(t *testing.T) RouteStore {
+				return NewFileRouteStore(t.TempDir()+"/route.json", 10*time.Millisecond)
+			},
+		},
+		{
+			name: "redis",
+			newStore: func(t *testing.T) RouteStore {
+				client := test.InitRedis().(*redis.Client)
+				if err := client.Ping(context.Background()).Err(); err != nil {
+					t.Skip("redis 不可用", err)
+				}
+				key := "case12:route:" + t.Name()
+				t.Cleanup(func() {
+					client.Del(context.Background(), key)
+				})
+				return NewRedisRouteStore(client, key)
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			store := tc.newStore(t)
+			leader, _ := newTestRing(12, "a", "b", "c")
+			follower, _ := newTestRing(12, "a", "b", "c")
+
+			_, err := store.Load(ctx)
+			assert.Equal(t, ErrNoSnapshot, err)
+			require.NoError(t, leader.Join(NewNode("d", "d_address", 1)))
+			// 版本由 store 分配，和本地的版本无关
+			epoch, err := PublishRoutes(ctx, leader, store)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(1), epoch)
+			s, err := store.Load(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, epoch, s.Epoch)
+			// 发布者自己不会重复应用
+			assert.ErrorIs(t, leader.ApplySnapshot(s), ErrStaleSnapshot)
+
+			go func() {
+				_ = SyncRoutes(ctx, follower, store)
+			}()
+			assert.Eventually(t, func() bool {
+				return follower.StoreEpoch() == epoch
+			}, time.Second, 10*time.Millisecond)
+			assertSameRoutes(t, leader, follower)
+
+			// 后面发布的路由表也会同步过去
+			require.NoError(t, leader.Leave("b"))
+			epoch, err = PublishRoutes(ctx, leader, store)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(2), epoch)
+			assert.Eventually(t, func() bool {
+				return follower.StoreEpoch() == epoch
+			}, time.Second, 10*time.Millisecond)
+			assertSameRoutes(t, leader, follower)
+
+			// 本地有自己的变更，依旧会应用 store 里面新的路由表
+			require.NoError(t, follower.Join(NewNode("e", "e_address", 1)))
+			require.NoError(t, leader.Leave("c"))
+			epoch, err = PublishRoutes(ctx, leader, store)
+			require.NoError(t, err)
+			assert.Eventually(t, func() bool {
+				return follower.StoreEpoch() == epoch
+			}, time.Second, 10*time.Millisecond)
+			assertSameRoutes(t, leader, follower)
+
+			// Watch 不会再回调 since 这个版本
+			got := make(chan uint64, 10)
+			go func() {
+				_ = store.Watch(ctx, epoch, func(s *RouteSnapshot) {
+					got <- s.Epoch
+				})
+			}()
+			next, err := PublishRoutes(ctx, leader, store)
+			require.NoError(t, err)
+			select {
+			case e := <-got:
+				assert.Equal(t, next, e)
+			case <-time.After(time.Second):
+				require.FailNow(t, "没有收到新的路由表")
+			}
+		})
+	}
+}
+
+// TestRouteStore_SameLocalEpoch 两个独立创建的 HashRing 本地的版本一样，发布之后都能同步出去
+func TestRouteStore_SameLocalEpoch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := NewFileRouteStore(t.TempDir()+"/route.json", 10*time.Millisecond)
+	r1, _ := newTestRing(12, "a", "b", "c")
+	r2, _ := newTestRing(12, "a", "b", "c")
+	follower, _ := newTestRing(12, "a", "b", "c")
+	require.NoError(t, r1.Join(NewNode("d", "d_address", 1)))
+	require.NoError(t, r2.Join(NewNode("e", "e_address", 1)))
+	require.Equal(t, r1.Epoch(), r2.Epoch())
+
+	e1, err := PublishRoutes(ctx, r1, store)
+	require.NoError(t, err)
+	e2, err := PublishRoutes(ctx, r2, store)
+	require.NoError(t, err)
+	assert.Greater(t, e2, e1)
+
+	go func() {
+		_ = SyncRoutes(ctx, follower, store)
+	}()
+	assert.Eventually(t, func() bool {
+		return follower.StoreEpoch() == e2
+	}, time.Second, 10*time.Millisecond)
+	assertSameRoutes(t, r2, follower)
+	// r1 也能收到 r2 发布的路由表
+	require.NoError(t, r1.ApplySnapshot(mustLoad(t, store)))
+	assertSameRoutes(t, r2, r1)
+}
+
+func mustLoad(t *testing.T, store RouteStore) *RouteSnapshot {
+	s, err := store.Load(context.Background())
+	require.NoError(t, err)
+	return s
+}
+
+// applySnapshot 模拟经过共享存储，先序列化再应用
+func applySnapshot(t *testing.T, h *HashRing, s *RouteSnapshot) {
+	data, err := json.Marshal(s)
+	require.NoError(t, err)
+	var res RouteSnapshot
+	require.NoError(t, json.Unmarshal(data, &res))
+	require.NoError(t, h.ApplySnapshot(&res))
+}
+
+// assertSameRoutes 只比较路由，发布者本地的版本和 store 分配的版本不一样
+func assertSameRoutes(t *testing.T, want, got *HashRing) {
+	for slot := 0; slot < want.slotNum; slot++ {
+		wn, wf := want.GetNodeWithFallback(slot)
+		gn, gf := got.GetNodeWithFallback(slot)
+		assert.Equal(t, wn.Name(), gn.Name(), "槽 %d", slot)
+		assert.Equal(t, nodeName(wf), nodeName(gf), "槽 %d", slot)
+	}
+}
@@ -0,0 +1,37 @@
+package case12
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// slotStatsShards 计数器分片的数量，同一个槽的并发计数会被打散到不同的分片上
+const slotStatsShards = 8
+
+// slotStats 每个槽的请求数，用分片的原子计数器实现，GetNode 的时候不需要加锁
+type slotStats struct {
+	shards [slotStatsShards][]atomic.Int64
+}
+
+func newSlotStats(slotNum int) *slotStats {
+	s := &slotStats{}
+	for i := range s.shards {
+		s.shards[i] = make([]atomic.Int64, slotNum)
+	}
+	return s
+}
+
+func (s *slotStats) inc(slot int) {
+	s.shards[rand.IntN(slotStatsShards)][slot].Add(1)
+}
+
+// snapshot 汇总所有分片，得到每个槽的请求数
+func (s *slotStats) snapshot() []int {
+	res := make([]int, len(s.shards[0]))
+	for i := range s.shards {
+		for slot := range s.shards[i] {
+			res[slot] += int(s.shards[i][slot].Load())
+		}
+	}
+	return res
+}
@@ -0,0 +1,199 @@
+// Package balancer 统一的客户端负载均衡，取代 v2、v3、v4 里面各自的 Client。
+// 调整权重的规则是可以替换的 Strategy，v2、v3 的规则是 RuleStrategy，v4 的状态机是 StateMachineStrategy；
+// 选择节点的算法是可以替换的 Picker。所有的状态都由 Balancer 加锁保护，对外只暴露副本
+package balancer
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 调用结果的分类，Strategy 根据这些错误调整权重
+var (
+	ErrNetworkFailure = errors.New("网络异常")
+	ErrTimeout        = errors.New("请求超时")
+	ErrThrottling     = errors.New("服务限流")
+	ErrCircuitBreaker = errors.New("服务熔断")
+)
+
+var (
+	ErrNoAvailableNodes = errors.New("没有可用的节点")
+	ErrNodeExists       = errors.New("节点已经存在")
+	ErrNodeNotFound     = errors.New("节点不存在")
+)
+
+// Status 节点的状态
+type Status string
+
+const (
+	StatusHealthy   Status = "healthy"   // 健康状态
+	StatusProbation Status = "probation" // 观察状态
+	StatusUnhealthy Status = "unhealthy" // 不健康状态
+)
+
+// NodeState 节点的权重和状态，由 Strategy 维护
+type NodeState struct {
+	Weight      int
+	Status      Status
+	LastCheckAt time.Time
+}
+
+// Available 不健康或者权重小于等于 0 的节点不参与选择
+func (s NodeState) Available() bool {
+	return s.Status != StatusUnhealthy && s.Weight > 0
+}
+
+// Node 节点的副本，修改它不会影响 Balancer
+type Node struct {
+	URL string
+	NodeState
+}
+
+// Strategy 根据调用结果调整节点的权重和状态。
+// 所有的方法都在 Balancer 的锁里面调用，实现不需要考虑并发
+type Strategy interface {
+	// Init 新加入的节点的状态，weight 是 Add 的时候传入的权重
+	Init(weight int, now time.Time) NodeState
+	// Report 一次调用结束之后的状态，err 为 nil 表示调用成功
+	Report(state NodeState, err error, now time.Time) NodeState
+	// Recover 每次选择节点之前调用，不健康的节点可以在这里恢复
+	Recover(state NodeState, now time.Time) NodeState
+}
+
+// Picker 在可用的节点里面选择一个，节点的权重都大于 0。
+// 所有的方法都在 Balancer 的锁里面调用，实现不需要考虑并发
+type Picker interface {
+	Pick(nodes []Node) (Node, error)
+}
+
+// Balancer 负载均衡器
+type Balancer struct {
+	lock     sync.Mutex
+	nodes    []*Node
+	strategy Strategy
+	picker   Picker
+	now      func() time.Time
+}
+
+type Option func(b *Balancer)
+
+// WithPicker 替换选择节点的算法，默认是 SmoothWeightedRoundRobin
+func WithPicker(p Picker) Option {
+	return func(b *Balancer) {
+		b.picker = p
+	}
+}
+
+func New(strategy Strategy, opts ...Option) *Balancer {
+	b := &Balancer{
+		strategy: strategy,
+		picker:   NewSmoothWeightedRoundRobin(),
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Add 加入节点，weight 的含义由 Strategy 决定
+func (b *Balancer) Add(url string, weight int) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.find(url) >= 0 {
+		return ErrNodeExists
+	}
+	b.nodes = append(b.nodes, &Node{URL: url, NodeState: b.strategy.Init(weight, b.now())})
+	return nil
+}
+
+func (b *Balancer) Remove(url string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	idx := b.find(url)
+	if idx < 0 {
+		return ErrNodeNotFound
+	}
+	b.nodes = append(b.nodes[:idx:idx], b.nodes[idx+1:]...)
+	return nil
+}
+
+// List 所有节点的副本，按照加入的顺序排列
+func (b *Balancer) List() []Node {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	res := make([]Node, 0, len(b.nodes))
+	for _, n := range b.nodes {
+		res = append(res, *n)
+	}
+	return res
+}
+
+// Get 某个节点的副本
+func (b *Balancer) Get(url string) (Node, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	idx := b.find(url)
+	if idx < 0 {
+		return Node{}, ErrNodeNotFound
+	}
+	return *b.nodes[idx], nil
+}
+
+// Pick 选择一个可用的节点
+func (b *Balancer) Pick() (Node, error) {
+	return b.PickExcept()
+}
+
+// PickExcept 选择一个可用的节点，跳过 urls 里面的节点，重试的时候用来换一个节点
+func (b *Balancer) PickExcept(urls ...string) (Node, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := b.now()
+	available := make([]Node, 0, len(b.nodes))
+	for _, n := range b.nodes {
+		n.NodeState = b.strategy.Recover(n.NodeState, now)
+		if n.Available() && !slices.Contains(urls, n.URL) {
+			available = append(available, *n)
+		}
+	}
+	if len(available) == 0 {
+		return Node{}, ErrNoAvailableNodes
+	}
+	return b.picker.Pick(available)
+}
+
+// Recover 恢复已经到了时间的节点。Pick 的时候也会恢复，
+// 这个方法给后台的定时任务用，没有请求的时候 Get 和 List 也能看到恢复之后的状态
+func (b *Balancer) Recover() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := b.now()
+	for _, n := range b.nodes {
+		n.NodeState = b.strategy.Recover(n.NodeState, now)
+	}
+}
+
+// Report 上报一次调用的结果，err 为 nil 表示调用成功
+func (b *Balancer) Report(url string, err error) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	idx := b.find(url)
+	if idx < 0 {
+		return ErrNodeNotFound
+	}
+	n := b.nodes[idx]
+	n.NodeState = b.strategy.Report(n.NodeState, err, b.now())
+	return nil
+}
+
+func (b *Balancer) find(url string) int {
+	for i, n := range b.nodes {
+		if n.URL == url {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,137 @@
+// Package balancertest 所有的 Strategy 和 Picker 组合都要通过的一致性测试
+package balancertest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/case11_20/case13/balancer"
+)
+
+// RunConformance newBalancer 每次返回一个新的、没有节点的 Balancer。
+// Strategy 需要满足：Add 的时候传入的权重在 [1, 3] 之间的时候原样使用；
+// ErrNetworkFailure 之后节点不可用，并且在测试期间不会恢复
+func RunConformance(t *testing.T, newBalancer func() *balancer.Balancer) {
+	t.Run("增删查", func(t *testing.T) {
+		b := newBalancer()
+		require.NoError(t, b.Add("a", 1))
+		require.NoError(t, b.Add("b", 1))
+		assert.Equal(t, balancer.ErrNodeExists, b.Add("a", 1))
+		assert.Equal(t, []string{"a", "b"}, urls(b.List()))
+
+		n, err := b.Get("b")
+		require.NoError(t, err)
+		assert.Equal(t, "b", n.URL)
+		assert.True(t, n.Available())
+
+		require.NoError(t, b.Remove("a"))
+		assert.Equal(t, balancer.ErrNodeNotFound, b.Remove("a"))
+		_, err = b.Get("a")
+		assert.Equal(t, balancer.ErrNodeNotFound, err)
+		assert.Equal(t, balancer.ErrNodeNotFound, b.Report("a", nil))
+		assert.Equal(t, []string{"b"}, urls(b.List()))
+	})
+
+	t.Run("没有节点", func(t *testing.T) {
+		b := newBalancer()
+		_, err := b.Pick()
+		assert.Equal(t, balancer.ErrNoAvailableNodes, err)
+	})
+
+	t.Run("只选择可用的节点", func(t *testing.T) {
+		b := newBalancer()
+		require.NoError(t, b.Add("a", 1))
+		require.NoError(t, b.Add("b", 1))
+		require.NoError(t, b.Report("a", balancer.ErrNetworkFailure))
+		n, err := b.Get("a")
+		require.NoError(t, err)
+		assert.False(t, n.Available())
+		for i := 0; i < 20; i++ {
+			n, err := b.Pick()
+			require.NoError(t, err)
+			assert.Equal(t, "b", n.URL)
+		}
+
+		require.NoError(t, b.Report("b", balancer.ErrNetworkFailure))
+		_, err = b.Pick()
+		assert.Equal(t, balancer.ErrNoAvailableNodes, err)
+	})
+
+	t.Run("跳过指定的节点", func(t *testing.T) {
+		b := newBalancer()
+		require.NoError(t, b.Add("a", 1))
+		require.NoError(t, b.Add("b", 1))
+		for i := 0; i < 20; i++ {
+			n, err := b.PickExcept("a")
+			require.NoError(t, err)
+			assert.Equal(t, "b", n.URL)
+		}
+		_, err := b.PickExcept("a", "b")
+		assert.Equal(t, balancer.ErrNoAvailableNodes, err)
+	})
+
+	t.Run("返回的是副本", func(t *testing.T) {
+		b := newBalancer()
+		require.NoError(t, b.Add("a", 1))
+		n, err := b.Pick()
+		require.NoError(t, err)
+		n.Weight = 0
+		n.URL = "b"
+		n, err = b.Get("a")
+		require.NoError(t, err)
+		assert.Equal(t, 1, n.Weight)
+	})
+
+	t.Run("按照权重选择", func(t *testing.T) {
+		b := newBalancer()
+		require.NoError(t, b.Add("a", 3))
+		require.NoError(t, b.Add("b", 1))
+		cnt := make(map[string]int)
+		for i := 0; i < 4000; i++ {
+			n, err := b.Pick()
+			require.NoError(t, err)
+			cnt[n.URL]++
+		}
+		assert.InDelta(t, 3000, cnt["a"], 200)
+		assert.InDelta(t, 1000, cnt["b"], 200)
+	})
+
+	t.Run("并发", func(t *testing.T) {
+		b := newBalancer()
+		for i := 0; i < 5; i++ {
+			require.NoError(t, b.Add(fmt.Sprintf("node_%d", i), 2))
+		}
+		errs := []error{nil, balancer.ErrTimeout, balancer.ErrThrottling, nil}
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					if n, err := b.Pick(); err == nil {
+						_ = b.Report(n.URL, errs[(i+j)%len(errs)])
+					}
+					if j%50 == 0 {
+						url := fmt.Sprintf("extra_%d_%d", i, j)
+						_ = b.Add(url, 1)
+						_ = b.List()
+						_ = b.Remove(url)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.Len(t, b.List(), 5)
+	})
+}
+
+func urls(nodes []balancer.Node) []string {
+	res := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		res = append(res, n.URL)
+	}
+	return res
+}
@@ -0,0 +1,40 @@
+package balancer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"interview-cases/case11_20/case13/balancer"
+	"interview-cases/case11_20/case13/balancer/balancertest"
+)
+
+func TestConformance(t *testing.T) {
+	strategies := map[string]func() balancer.Strategy{
+		"规则": func() balancer.Strategy {
+			return balancer.NewRuleStrategy()
+		},
+		"状态机": func() balancer.Strategy {
+			s, err := balancer.NewStateMachineStrategy(1, 100, 10, time.Hour)
+			require.NoError(t, err)
+			return s
+		},
+	}
+	pickers := map[string]func() balancer.Picker{
+		"平滑加权轮询": func() balancer.Picker {
+			return balancer.NewSmoothWeightedRoundRobin()
+		},
+		"加权随机": func() balancer.Picker {
+			return balancer.WeightedRandom{}
+		},
+	}
+	for sName, newStrategy := range strategies {
+		for pName, newPicker := range pickers {
+			t.Run(sName+"_"+pName, func(t *testing.T) {
+				balancertest.RunConformance(t, func() *balancer.Balancer {
+					return balancer.New(newStrategy(), balancer.WithPicker(newPicker()))
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package balancer
+
+import "math/rand/v2"
+
+// SmoothWeightedRoundRobin 平滑加权轮询，和 nginx 的一样。
+// 每个节点的当前权重按照 URL 保存，节点列表和权重变化之后依旧平滑
+type SmoothWeightedRoundRobin struct {
+	current map[string]int
+}
+
+func NewSmoothWeightedRoundRobin() *SmoothWeightedRoundRobin {
+	return &SmoothWeightedRoundRobin{current: make(map[string]int)}
+}
+
+func (p *SmoothWeightedRoundRobin) Pick(nodes []Node) (Node, error) {
+	if len(nodes) == 0 {
+		return Node{}, ErrNoAvailableNodes
+	}
+	total, best := 0, -1
+	seen := make(map[string]struct{}, len(nodes))
+	for i, n := range nodes {
+		seen[n.URL] = struct{}{}
+		total += n.Weight
+		p.current[n.URL] += n.Weight
+		if best < 0 || p.current[n.URL] > p.current[nodes[best].URL] {
+			best = i
+		}
+	}
+	p.current[nodes[best].URL] -= total
+	// 已经不可用或者被删除的节点重新开始
+	for url := range p.current {
+		if _, ok := seen[url]; !ok {
+			delete(p.current, url)
+		}
+	}
+	return nodes[best], nil
+}
+
+// WeightedRandom 按照权重随机选择
+type WeightedRandom struct{}
+
+func (WeightedRandom) Pick(nodes []Node) (Node, error) {
+	total := 0
+	for _, n := range nodes {
+		total += n.Weight
+	}
+	if total <= 0 {
+		return Node{}, ErrNoAvailableNodes
+	}
+	r := rand.IntN(total)
+	for _, n := range nodes {
+		if r < n.Weight {
+			return n, nil
+		}
+		r -= n.Weight
+	}
+	return nodes[len(nodes)-1], nil
+}
@@ -0,0 +1,67 @@
+package balancer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmoothWeightedRoundRobin(t *testing.T) {
+	testCases := []struct {
+		name      string
+		nodes     []Node
+		wantOrder []string
+	}{
+		{
+			name:      "平滑",
+			nodes:     []Node{{URL: "a", NodeState: NodeState{Weight: 5}}, {URL: "b", NodeState: NodeState{Weight: 1}}, {URL: "c", NodeState: NodeState{Weight: 1}}},
+			wantOrder: []string{"a", "a", "b", "a", "c", "a", "a"},
+		},
+		{
+			name:      "权重一样",
+			nodes:     []Node{{URL: "a", NodeState: NodeState{Weight: 1}}, {URL: "b", NodeState: NodeState{Weight: 1}}},
+			wantOrder: []string{"a", "b", "a", "b"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewSmoothWeightedRoundRobin()
+			var order []string
+			for range tc.wantOrder {
+				n, err := p.Pick(tc.nodes)
+				require.NoError(t, err)
+				order = append(order, n.URL)
+			}
+			assert.Equal(t, tc.wantOrder, order)
+		})
+	}
+
+	_, err := NewSmoothWeightedRoundRobin().Pick(nil)
+	assert.Equal(t, ErrNoAvailableNodes, err)
+}
+
+func TestSmoothWeightedRoundRobin_Change(t *testing.T) {
+	p := NewSmoothWeightedRoundRobin()
+	a := Node{URL: "a", NodeState: NodeState{Weight: 3}}
+	b := Node{URL: "b", NodeState: NodeState{Weight: 2}}
+	c := Node{URL: "c", NodeState: NodeState{Weight: 1}}
+	orders := [][]Node{{a, b, c}, {c, b, a}, {b, a, c}}
+	// 当前权重按照 URL 保存，节点的顺序每次都变，每一轮还是严格按照权重分配
+	cnt := make(map[string]int)
+	for i := 0; i < 12; i++ {
+		n, err := p.Pick(orders[i%len(orders)])
+		require.NoError(t, err)
+		cnt[n.URL]++
+	}
+	assert.Equal(t, map[string]int{"a": 6, "b": 4, "c": 2}, cnt)
+
+	// a 不可用的时候不会被选到，它的当前权重也被清掉了
+	for i := 0; i < 3; i++ {
+		n, err := p.Pick([]Node{b, c})
+		require.NoError(t, err)
+		assert.NotEqual(t, "a", n.URL)
+	}
+	_, ok := p.current["a"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,106 @@
+package balancer
+
+import (
+	"errors"
+	"time"
+)
+
+// 默认的权重上下限
+const (
+	DefaultMinWeight = 1
+	DefaultMaxWeight = 100
+)
+
+// RuleStrategy v2、v3 的规则：
+// 网络异常或者熔断的时候权重变成 0，不再参与选择；超时权重减 1；限流权重减半；
+// 成功的时候权重不变。权重不会低于 MinWeight，除非变成 0。
+// 和 v2、v3 一样没有权重上限，Add 的时候传入多少就是多少
+type RuleStrategy struct {
+	MinWeight int
+}
+
+func NewRuleStrategy() *RuleStrategy {
+	return &RuleStrategy{MinWeight: DefaultMinWeight}
+}
+
+func (s *RuleStrategy) Init(weight int, now time.Time) NodeState {
+	return NodeState{Weight: weight, Status: StatusHealthy, LastCheckAt: now}
+}
+
+func (s *RuleStrategy) Report(state NodeState, err error, now time.Time) NodeState {
+	state.LastCheckAt = now
+	switch {
+	case errors.Is(err, ErrNetworkFailure), errors.Is(err, ErrCircuitBreaker):
+		state.Weight = 0
+	case errors.Is(err, ErrTimeout):
+		state.Weight = max(state.Weight-1, s.MinWeight)
+	case errors.Is(err, ErrThrottling):
+		state.Weight = max(state.Weight/2, s.MinWeight)
+	}
+	return state
+}
+
+// Recover 权重变成 0 的节点不会自动恢复
+func (s *RuleStrategy) Recover(state NodeState, now time.Time) NodeState {
+	return state
+}
+
+// StateMachineStrategy v4 的状态机：
+// 成功的时候进入健康状态，权重加 1；网络异常或者熔断的时候进入不健康状态；
+// 超时进入观察状态，权重减少 10%；限流进入观察状态，权重减半。
+// 不健康的节点过了 RecoveryInterval 之后进入观察状态，权重从 MinWeight 开始
+type StateMachineStrategy struct {
+	MinWeight        int
+	MaxWeight        int
+	DefaultWeight    int
+	RecoveryInterval time.Duration
+}
+
+func NewStateMachineStrategy(minWeight, maxWeight, defaultWeight int,
+	recoveryInterval time.Duration) (*StateMachineStrategy, error) {
+	if minWeight < 0 || maxWeight <= minWeight || defaultWeight < minWeight || defaultWeight > maxWeight {
+		return nil, errors.New("无效的权重配置")
+	}
+	return &StateMachineStrategy{
+		MinWeight:        minWeight,
+		MaxWeight:        maxWeight,
+		DefaultWeight:    defaultWeight,
+		RecoveryInterval: recoveryInterval,
+	}, nil
+}
+
+// Init weight 小于等于 0 的时候使用 DefaultWeight
+func (s *StateMachineStrategy) Init(weight int, now time.Time) NodeState {
+	if weight <= 0 {
+		weight = s.DefaultWeight
+	}
+	return NodeState{Weight: min(max(weight, s.MinWeight), s.MaxWeight), Status: StatusHealthy, LastCheckAt: now}
+}
+
+func (s *StateMachineStrategy) Report(state NodeState, err error, now time.Time) NodeState {
+	state.LastCheckAt = now
+	switch {
+	case err == nil:
+		state.Status = StatusHealthy
+		state.Weight = min(state.Weight+1, s.MaxWeight)
+	case errors.Is(err, ErrNetworkFailure), errors.Is(err, ErrCircuitBreaker):
+		state.Status = StatusUnhealthy
+		state.Weight = s.MinWeight - 1
+	case errors.Is(err, ErrTimeout):
+		state.Status = StatusProbation
+		state.Weight = max(state.Weight*9/10, s.MinWeight)
+	case errors.Is(err, ErrThrottling):
+		state.Status = StatusProbation
+		state.Weight = max(state.Weight/2, s.MinWeight)
+	}
+	return state
+}
+
+func (s *StateMachineStrategy) Recover(state NodeState, now time.Time) NodeState {
+	if state.Status == StatusUnhealthy && now.Sub(state.LastCheckAt) >= s.RecoveryInterval {
+		state.Status = StatusProbation
+		state.Weight = s.MinWeight
+		state.LastCheckAt = now
+	}
+	return state
+}
@@ -0,0 +1,107 @@
+package balancer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleStrategy(t *testing.T) {
+	testCases := []struct {
+		name       string
+		errs       []error
+		wantWeight int
+	}{
+		{name: "成功权重不变", errs: []error{nil}, wantWeight: 100},
+		{name: "网络异常", errs: []error{ErrNetworkFailure}, wantWeight: 0},
+		{name: "熔断", errs: []error{ErrCircuitBreaker}, wantWeight: 0},
+		{name: "超时", errs: []error{ErrTimeout}, wantWeight: 99},
+		{name: "限流", errs: []error{ErrThrottling}, wantWeight: 50},
+		{name: "网络异常之后成功也不会恢复", errs: []error{ErrNetworkFailure, nil}, wantWeight: 0},
+		{name: "多次限流不低于最小权重", errs: []error{ErrThrottling, ErrThrottling, ErrThrottling,
+			ErrThrottling, ErrThrottling, ErrThrottling, ErrThrottling, ErrThrottling}, wantWeight: 1},
+		{name: "其它错误权重不变", errs: []error{errors.New("mock error")}, wantWeight: 100},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := New(NewRuleStrategy())
+			require.NoError(t, b.Add("a", 100))
+			for _, err := range tc.errs {
+				require.NoError(t, b.Report("a", err))
+			}
+			n, err := b.Get("a")
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantWeight, n.Weight)
+		})
+	}
+
+	// 和 v2、v3 一样，超过 DefaultMaxWeight 也不会截断
+	b := New(NewRuleStrategy())
+	require.NoError(t, b.Add("a", 200))
+	n, err := b.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, 200, n.Weight)
+}
+
+func TestStateMachineStrategy(t *testing.T) {
+	_, err := NewStateMachineStrategy(10, 5, 7, time.Second)
+	assert.Error(t, err)
+
+	testCases := []struct {
+		name       string
+		errs       []error
+		wantWeight int
+		wantStatus Status
+	}{
+		{name: "成功权重加一", errs: []error{nil}, wantWeight: 6, wantStatus: StatusHealthy},
+		{name: "成功不超过最大权重", errs: []error{nil, nil, nil, nil, nil, nil}, wantWeight: 10, wantStatus: StatusHealthy},
+		{name: "网络异常", errs: []error{ErrNetworkFailure}, wantWeight: 0, wantStatus: StatusUnhealthy},
+		{name: "熔断", errs: []error{ErrCircuitBreaker}, wantWeight: 0, wantStatus: StatusUnhealthy},
+		{name: "超时", errs: []error{ErrTimeout}, wantWeight: 4, wantStatus: StatusProbation},
+		{name: "限流", errs: []error{ErrThrottling}, wantWeight: 2, wantStatus: StatusProbation},
+		{name: "观察状态成功之后恢复健康", errs: []error{ErrThrottling, nil}, wantWeight: 3, wantStatus: StatusHealthy},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewStateMachineStrategy(1, 10, 5, time.Second)
+			require.NoError(t, err)
+			b := New(s)
+			require.NoError(t, b.Add("a", 0))
+			for _, err := range tc.errs {
+				require.NoError(t, b.Report("a", err))
+			}
+			n, err := b.Get("a")
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantWeight, n.Weight)
+			assert.Equal(t, tc.wantStatus, n.Status)
+		})
+	}
+}
+
+func TestStateMachineStrategy_Recover(t *testing.T) {
+	s, err := NewStateMachineStrategy(1, 10, 5, time.Second)
+	require.NoError(t, err)
+	b := New(s)
+	now := time.UnixMilli(0)
+	b.now = func() time.Time {
+		return now
+	}
+	require.NoError(t, b.Add("a", 0))
+	require.NoError(t, b.Report("a", ErrNetworkFailure))
+	_, err = b.Pick()
+	assert.Equal(t, ErrNoAvailableNodes, err)
+
+	// 过了恢复时间之后进入观察状态，从最小权重开始
+	now = now.Add(time.Second)
+	b.Recover()
+	n, err := b.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, NodeState{Weight: 1, Status: StatusProbation, LastCheckAt: now}, n.NodeState)
+	n, err = b.Pick()
+	require.NoError(t, err)
+	assert.Equal(t, "a", n.URL)
+	assert.Equal(t, NodeState{Weight: 1, Status: StatusProbation, LastCheckAt: now}, n.NodeState)
+}
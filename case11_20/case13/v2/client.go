@@ -27,6 +27,8 @@ type ServiceNode struct {
 }
 
 // Client 表示负载均衡客户端
+//
+// Deprecated: 使用 balancer 包，balancer.RuleStrategy 是同样的规则，并且是并发安全的
 type Client struct {
 	nodes sync.Map // 存储服务节点的并发安全map
 }
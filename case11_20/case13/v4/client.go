@@ -5,61 +5,66 @@ import (
 	"log"
 	"sync"
 	"time"
+
+	"interview-cases/case11_20/case13/balancer"
 )
 
 // 节点状态常量
 const (
-	StatusHealthy   = "healthy"   // 健康状态
-	StatusUnhealthy = "unhealthy" // 不健康状态
-	StatusProbation = "probation" // 观察状态
+	StatusHealthy   = string(balancer.StatusHealthy)   // 健康状态
+	StatusUnhealthy = string(balancer.StatusUnhealthy) // 不健康状态
+	StatusProbation = string(balancer.StatusProbation) // 观察状态
 )
 
-// 错误类型
+// 错误类型，和 balancer 包里面的是同一个，Strategy 根据它们调整权重
 var (
-	ErrNoAvailableNodes = errors.New("没有可用的节点")
-	ErrNetworkFailure   = errors.New("网络故障")
-	ErrTimeout          = errors.New("请求超时")
-	ErrThrottling       = errors.New("服务限流")
-	ErrCircuitBreaker   = errors.New("熔断器开启")
+	ErrNoAvailableNodes = balancer.ErrNoAvailableNodes
+	ErrNetworkFailure   = balancer.ErrNetworkFailure
+	ErrTimeout          = balancer.ErrTimeout
+	ErrThrottling       = balancer.ErrThrottling
+	ErrCircuitBreaker   = balancer.ErrCircuitBreaker
 )
 
-// LoadBalancer 定义负载均衡器接口
+// LoadBalancer 定义负载均衡器接口，Select 在 balancer.Balancer 的锁里面调用，不会并发执行
 type LoadBalancer interface {
 	Select([]*Node) (*Node, error)
 }
 
 // Node 表示一个服务节点
 type Node struct {
-	URL         string    // 节点URL
-	Weight      int       // 节点权重
-	Status      string    // 节点状态
-	LastCheckAt time.Time // 上次检查时间
+	URL            string    // 节点URL
+	Weight         int       // 节点权重
+	Status         string    // 节点状态
+	LastCheckAt    time.Time // 上次检查时间
+	ThrottledUntil time.Time // 节点限流的时候要求在这个时间之前不要再发请求
 }
 
-// Client 表示负载均衡客户端
+// Client 表示负载均衡客户端。
+// 权重和状态由 balancer.Balancer 加上 balancer.StateMachineStrategy 维护，Client 只多了限流退避。
+//
+// Deprecated: 新代码直接使用 balancer.New(balancer.NewStateMachineStrategy(...))，
+// Client 留给 v4.Transport 和 v4/grpclb 使用
 type Client struct {
-	healthyNodes     []*Node
-	probationNodes   []*Node
-	unhealthyNodes   []*Node
-	minWeight        int
-	maxWeight        int
-	defaultWeight    int
-	loadBalancer     LoadBalancer
-	mu               sync.RWMutex
+	balancer *balancer.Balancer
+	strategy *balancer.StateMachineStrategy
+
+	mu        sync.Mutex
+	throttled map[string]time.Time
+
 	recoveryInterval time.Duration
 	stopChan         chan struct{} // 用于停止后台恢复协程
 }
 
 // NewClient 创建一个新的客户端实例
 func NewClient(minWeight, maxWeight, defaultWeight int, lb LoadBalancer, recoveryInterval time.Duration) (*Client, error) {
-	if minWeight < 0 || maxWeight <= minWeight || defaultWeight < minWeight || defaultWeight > maxWeight {
-		return nil, errors.New("无效的权重配置")
+	s, err := balancer.NewStateMachineStrategy(minWeight, maxWeight, defaultWeight, recoveryInterval)
+	if err != nil {
+		return nil, err
 	}
 	c := &Client{
-		minWeight:        minWeight,
-		maxWeight:        maxWeight,
-		defaultWeight:    defaultWeight,
-		loadBalancer:     lb,
+		balancer:         balancer.New(s, balancer.WithPicker(picker{lb: lb})),
+		strategy:         s,
+		throttled:        make(map[string]time.Time),
 		recoveryInterval: recoveryInterval,
 		stopChan:         make(chan struct{}),
 	}
@@ -86,182 +91,117 @@ func (c *Client) Close() {
 	close(c.stopChan)
 }
 
-// AddNode 添加一个新的服务节点
+// AddNode 添加一个新的服务节点，权重是 defaultWeight，节点已经存在的时候什么也不做
 func (c *Client) AddNode(url string) {
+	_ = c.balancer.Add(url, 0)
+}
+
+// RemoveNode 删除节点，节点不存在的时候什么也不做
+func (c *Client) RemoveNode(url string) {
+	_ = c.balancer.Remove(url)
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	node := &Node{URL: url, Weight: c.defaultWeight, Status: StatusHealthy, LastCheckAt: time.Now()}
-	c.healthyNodes = append(c.healthyNodes, node)
+	delete(c.throttled, url)
 }
 
 // GetNode 获取一个可用的服务节点
 func (c *Client) GetNode() (*Node, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	availableNodes := c.getAvailableNodes()
-	if len(availableNodes) == 0 {
-		return nil, ErrNoAvailableNodes
-	}
-	return c.loadBalancer.Select(availableNodes)
+	return c.GetNodeExcept()
 }
 
-// getAvailableNodes 获取所有可用的节点，并进行惰性恢复检查
-func (c *Client) getAvailableNodes() []*Node {
-	// 获取当前时间，用于比较节点的最后检查时间
-	now := time.Now()
-	// 创建一个切片来存储所有可用的节点
-	var availableNodes []*Node
-
-	// 定义一个内部函数，用于检查和追加节点
-	checkAndAppend := func(nodes []*Node) {
-		for _, node := range nodes {
-			// 检查不健康节点是否可以恢复
-			if node.Status == StatusUnhealthy && now.Sub(node.LastCheckAt) >= c.recoveryInterval {
-				// 将不健康节点移动到试用状态
-				c.moveNode(node, StatusProbation)
-				// 重置节点权重为最小值
-				node.Weight = c.minWeight
-				// 更新节点的最后检查时间
-				node.LastCheckAt = now
-			}
-			// 如果节点状态不是 StatusUnhealthy，则认为它是可用的
-			if node.Status != StatusUnhealthy {
-				availableNodes = append(availableNodes, node)
-			}
-		}
+// GetNodeExcept 获取一个可用的服务节点，跳过 urls 里面的节点，重试的时候用来换一个节点。
+// 还在限流退避的节点也会被跳过
+func (c *Client) GetNodeExcept(urls ...string) (*Node, error) {
+	except := append(urls[:len(urls):len(urls)], c.throttledURLs(time.Now())...)
+	n, err := c.balancer.PickExcept(except...)
+	if err != nil {
+		return nil, err
 	}
-
-	// 检查并追加健康节点
-	checkAndAppend(c.healthyNodes)
-	// 检查并追加试用节点
-	checkAndAppend(c.probationNodes)
-
-	// 返回所有可用节点
-	return availableNodes
+	return toNode(n), nil
 }
 
-// UpdateNodeStatus 更新节点状态和权重
-func (c *Client) UpdateNodeStatus(url string, err error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
+// Node 返回节点当前状态的副本
+func (c *Client) Node(url string) (Node, bool) {
 	node := c.findNode(url)
 	if node == nil {
-		log.Printf("未知节点: %s\n", url)
-		return
+		return Node{}, false
 	}
+	return *node, true
+}
 
-	node.LastCheckAt = time.Now()
-
-	if err == nil {
-		c.moveNode(node, StatusHealthy)
-		node.Weight = min(node.Weight+1, c.maxWeight)
-	} else if errors.Is(err, ErrNetworkFailure) || errors.Is(err, ErrCircuitBreaker) {
-		c.moveNode(node, StatusUnhealthy)
-		node.Weight = c.minWeight - 1
-	} else if errors.Is(err, ErrTimeout) {
-		c.moveNode(node, StatusProbation)
-		node.Weight = max(node.Weight*9/10, c.minWeight) // 减少10%
-	} else if errors.Is(err, ErrThrottling) {
-		c.moveNode(node, StatusProbation)
-		node.Weight = max(node.Weight/2, c.minWeight) // 减半
+// Throttle 节点要求在 until 之前不要再发请求，比如 429 响应的 Retry-After
+func (c *Client) Throttle(url string, until time.Time) {
+	if _, err := c.balancer.Get(url); err != nil {
+		return
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.throttled[url] = until
 }
 
-// findNode 查找指定URL的节点
-func (c *Client) findNode(url string) *Node {
-	for _, node := range c.healthyNodes {
-		if node.URL == url {
-			return node
-		}
-	}
-	for _, node := range c.probationNodes {
-		if node.URL == url {
-			return node
-		}
-	}
-	for _, node := range c.unhealthyNodes {
-		if node.URL == url {
-			return node
+// throttledURLs 还在限流退避的节点，顺便删掉已经过期的
+func (c *Client) throttledURLs(now time.Time) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var res []string
+	for url, until := range c.throttled {
+		if now.Before(until) {
+			res = append(res, url)
+		} else {
+			delete(c.throttled, url)
 		}
 	}
-	return nil
+	return res
 }
 
-// moveNode 将节点移动到指定状态
-func (c *Client) moveNode(node *Node, toStatus string) {
-	if node.Status == toStatus {
-		return
-	}
-
-	// 从原列表中移除
-	var fromList *[]*Node
-	switch node.Status {
-	case StatusHealthy:
-		fromList = &c.healthyNodes
-	case StatusProbation:
-		fromList = &c.probationNodes
-	case StatusUnhealthy:
-		fromList = &c.unhealthyNodes
-	default:
-		panic("不该触发,节点状态错误")
-	}
-
-	for i, n := range *fromList {
-		if n == node {
-			*fromList = append((*fromList)[:i], (*fromList)[i+1:]...)
-			break
-		}
+// UpdateNodeStatus 更新节点状态和权重
+func (c *Client) UpdateNodeStatus(url string, err error) {
+	if errors.Is(c.balancer.Report(url, err), balancer.ErrNodeNotFound) {
+		log.Printf("未知节点: %s\n", url)
 	}
+}
 
-	// 添加到新列表
-	var toList *[]*Node
-	switch toStatus {
-	case StatusHealthy:
-		toList = &c.healthyNodes
-	case StatusProbation:
-		toList = &c.probationNodes
-	case StatusUnhealthy:
-		toList = &c.unhealthyNodes
-	default:
-		panic("不该触发,节点状态错误")
+// findNode 查找指定URL的节点，返回的是副本，找不到返回 nil
+func (c *Client) findNode(url string) *Node {
+	n, err := c.balancer.Get(url)
+	if err != nil {
+		return nil
 	}
-
-	*toList = append(*toList, node)
-	node.Status = toStatus
+	node := toNode(n)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node.ThrottledUntil = c.throttled[url]
+	return node
 }
 
 // tryRecoverNodes 尝试恢复不健康的节点
 func (c *Client) tryRecoverNodes() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.balancer.Recover()
+}
 
-	now := time.Now()
-	for i := 0; i < len(c.unhealthyNodes); {
-		node := c.unhealthyNodes[i]
-		if now.Sub(node.LastCheckAt) >= c.recoveryInterval {
-			node.Weight = c.minWeight
-			c.moveNode(node, StatusProbation)
-			node.LastCheckAt = now
-		} else {
-			i++
-		}
-	}
+func toNode(n balancer.Node) *Node {
+	return &Node{URL: n.URL, Weight: n.Weight, Status: string(n.Status), LastCheckAt: n.LastCheckAt}
 }
 
-// min 返回两个整数中的较小值
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
+// picker 把 LoadBalancer 适配成 balancer.Picker
+type picker struct {
+	lb LoadBalancer
 }
 
-// max 返回两个整数中的较大值
-func max(a, b int) int {
-	if a > b {
-		return a
+func (p picker) Pick(nodes []balancer.Node) (balancer.Node, error) {
+	candidates := make([]*Node, 0, len(nodes))
+	for _, n := range nodes {
+		candidates = append(candidates, toNode(n))
+	}
+	selected, err := p.lb.Select(candidates)
+	if err != nil {
+		return balancer.Node{}, err
+	}
+	// LoadBalancer 返回的不一定是传进去的指针，按照 URL 找回来
+	for _, n := range nodes {
+		if n.URL == selected.URL {
+			return n, nil
+		}
 	}
-	return b
+	return balancer.Node{}, ErrNoAvailableNodes
 }
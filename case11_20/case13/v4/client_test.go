@@ -31,9 +31,9 @@ func TestNewClient(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.NotNil(t, client)
-	assert.Equal(t, 1, client.minWeight)
-	assert.Equal(t, 10, client.maxWeight)
-	assert.Equal(t, 5, client.defaultWeight)
+	assert.Equal(t, 1, client.strategy.MinWeight)
+	assert.Equal(t, 10, client.strategy.MaxWeight)
+	assert.Equal(t, 5, client.strategy.DefaultWeight)
 	assert.Equal(t, time.Second, client.recoveryInterval)
 }
 
@@ -53,10 +53,10 @@ func TestAddNode(t *testing.T) {
 
 	client.AddNode("http://example.com")
 
-	assert.Len(t, client.healthyNodes, 1)
-	assert.Equal(t, "http://example.com", client.healthyNodes[0].URL)
-	assert.Equal(t, 5, client.healthyNodes[0].Weight)
-	assert.Equal(t, StatusHealthy, client.healthyNodes[0].Status)
+	assert.Len(t, nodesWithStatus(client, StatusHealthy), 1)
+	assert.Equal(t, "http://example.com", nodesWithStatus(client, StatusHealthy)[0].URL)
+	assert.Equal(t, 5, nodesWithStatus(client, StatusHealthy)[0].Weight)
+	assert.Equal(t, StatusHealthy, nodesWithStatus(client, StatusHealthy)[0].Status)
 }
 
 // TestGetNode 测试获取节点功能
@@ -65,7 +65,7 @@ func TestGetNode(t *testing.T) {
 	client, _ := NewClient(1, 10, 5, lb, time.Second)
 	client.AddNode("http://example.com")
 
-	expectedNode := client.healthyNodes[0]
+	expectedNode := nodesWithStatus(client, StatusHealthy)[0]
 	lb.On("Select", mock.Anything).Return(expectedNode, nil)
 
 	node, err := client.GetNode()
@@ -95,9 +95,9 @@ func TestUpdateNodeStatus_Success(t *testing.T) {
 
 	client.UpdateNodeStatus("http://example.com", nil)
 
-	assert.Len(t, client.healthyNodes, 1)
-	assert.Equal(t, StatusHealthy, client.healthyNodes[0].Status)
-	assert.Equal(t, 6, client.healthyNodes[0].Weight)
+	assert.Len(t, nodesWithStatus(client, StatusHealthy), 1)
+	assert.Equal(t, StatusHealthy, nodesWithStatus(client, StatusHealthy)[0].Status)
+	assert.Equal(t, 6, nodesWithStatus(client, StatusHealthy)[0].Weight)
 }
 
 // TestUpdateNodeStatus_NetworkFailure 测试网络故障时的节点状态更新
@@ -108,9 +108,9 @@ func TestUpdateNodeStatus_NetworkFailure(t *testing.T) {
 
 	client.UpdateNodeStatus("http://example.com", ErrNetworkFailure)
 
-	assert.Len(t, client.unhealthyNodes, 1)
-	assert.Equal(t, StatusUnhealthy, client.unhealthyNodes[0].Status)
-	assert.Equal(t, 0, client.unhealthyNodes[0].Weight)
+	assert.Len(t, nodesWithStatus(client, StatusUnhealthy), 1)
+	assert.Equal(t, StatusUnhealthy, nodesWithStatus(client, StatusUnhealthy)[0].Status)
+	assert.Equal(t, 0, nodesWithStatus(client, StatusUnhealthy)[0].Weight)
 }
 
 // TestUpdateNodeStatus_CircuitBreaker 测试熔断器开启时的节点状态更新
@@ -121,9 +121,9 @@ func TestUpdateNodeStatus_CircuitBreaker(t *testing.T) {
 
 	client.UpdateNodeStatus("http://example.com", ErrCircuitBreaker)
 
-	assert.Len(t, client.unhealthyNodes, 1)
-	assert.Equal(t, StatusUnhealthy, client.unhealthyNodes[0].Status)
-	assert.Equal(t, 0, client.unhealthyNodes[0].Weight)
+	assert.Len(t, nodesWithStatus(client, StatusUnhealthy), 1)
+	assert.Equal(t, StatusUnhealthy, nodesWithStatus(client, StatusUnhealthy)[0].Status)
+	assert.Equal(t, 0, nodesWithStatus(client, StatusUnhealthy)[0].Weight)
 }
 
 // TestUpdateNodeStatus_Timeout 测试超时时的节点状态更新
@@ -134,9 +134,9 @@ func TestUpdateNodeStatus_Timeout(t *testing.T) {
 
 	client.UpdateNodeStatus("http://example.com", ErrTimeout)
 
-	assert.Len(t, client.probationNodes, 1)
-	assert.Equal(t, StatusProbation, client.probationNodes[0].Status)
-	assert.Equal(t, 4, client.probationNodes[0].Weight) // 5 * 9/10 = 4.5, 向下取整为4
+	assert.Len(t, nodesWithStatus(client, StatusProbation), 1)
+	assert.Equal(t, StatusProbation, nodesWithStatus(client, StatusProbation)[0].Status)
+	assert.Equal(t, 4, nodesWithStatus(client, StatusProbation)[0].Weight) // 5 * 9/10 = 4.5, 向下取整为4
 }
 
 // TestUpdateNodeStatus_Throttling 测试限流时的节点状态更新
@@ -147,9 +147,9 @@ func TestUpdateNodeStatus_Throttling(t *testing.T) {
 
 	client.UpdateNodeStatus("http://example.com", ErrThrottling)
 
-	assert.Len(t, client.probationNodes, 1)
-	assert.Equal(t, StatusProbation, client.probationNodes[0].Status)
-	assert.Equal(t, 2, client.probationNodes[0].Weight) // 5 / 2 = 2.5, 向下取整为2
+	assert.Len(t, nodesWithStatus(client, StatusProbation), 1)
+	assert.Equal(t, StatusProbation, nodesWithStatus(client, StatusProbation)[0].Status)
+	assert.Equal(t, 2, nodesWithStatus(client, StatusProbation)[0].Weight) // 5 / 2 = 2.5, 向下取整为2
 }
 
 // TestUpdateNodeStatus_OtherError 测试其他错误时的节点状态更新
@@ -160,9 +160,9 @@ func TestUpdateNodeStatus_OtherError(t *testing.T) {
 
 	client.UpdateNodeStatus("http://example.com", errors.New("未知错误"))
 
-	assert.Len(t, client.healthyNodes, 1)
-	assert.Equal(t, StatusHealthy, client.healthyNodes[0].Status)
-	assert.Equal(t, 5, client.healthyNodes[0].Weight) // 权重不变
+	assert.Len(t, nodesWithStatus(client, StatusHealthy), 1)
+	assert.Equal(t, StatusHealthy, nodesWithStatus(client, StatusHealthy)[0].Status)
+	assert.Equal(t, 5, nodesWithStatus(client, StatusHealthy)[0].Weight) // 权重不变
 }
 
 // TestUpdateNodeStatus_NonexistentNode 测试更新不存在的节点
@@ -172,9 +172,9 @@ func TestUpdateNodeStatus_NonexistentNode(t *testing.T) {
 
 	client.UpdateNodeStatus("http://nonexistent.com", nil)
 
-	assert.Len(t, client.healthyNodes, 0)
-	assert.Len(t, client.probationNodes, 0)
-	assert.Len(t, client.unhealthyNodes, 0)
+	assert.Len(t, nodesWithStatus(client, StatusHealthy), 0)
+	assert.Len(t, nodesWithStatus(client, StatusProbation), 0)
+	assert.Len(t, nodesWithStatus(client, StatusUnhealthy), 0)
 }
 
 // TestTryRecoverNodes 测试节点恢复功能
@@ -184,15 +184,53 @@ func TestTryRecoverNodes(t *testing.T) {
 	client.AddNode("http://example.com")
 
 	client.UpdateNodeStatus("http://example.com", ErrNetworkFailure)
-	assert.Len(t, client.unhealthyNodes, 1)
+	assert.Len(t, nodesWithStatus(client, StatusUnhealthy), 1)
 
 	time.Sleep(2 * time.Millisecond) // 等待恢复间隔
 	client.tryRecoverNodes()
 
-	assert.Len(t, client.unhealthyNodes, 0)
-	assert.Len(t, client.probationNodes, 1)
-	assert.Equal(t, StatusProbation, client.probationNodes[0].Status)
-	assert.Equal(t, client.minWeight, client.probationNodes[0].Weight)
+	assert.Len(t, nodesWithStatus(client, StatusUnhealthy), 0)
+	assert.Len(t, nodesWithStatus(client, StatusProbation), 1)
+	assert.Equal(t, StatusProbation, nodesWithStatus(client, StatusProbation)[0].Status)
+	assert.Equal(t, client.strategy.MinWeight, nodesWithStatus(client, StatusProbation)[0].Weight)
+}
+
+// TestGetNodeRecover 选择节点的时候顺便恢复到了时间的不健康节点
+func TestGetNodeRecover(t *testing.T) {
+	client, _ := NewClient(1, 10, 5, &WeightedRoundRobinLoadBalancer{}, 10*time.Millisecond)
+	// 停掉后台恢复，只靠 GetNode 恢复
+	client.Close()
+	client.AddNode("http://example.com")
+	client.UpdateNodeStatus("http://example.com", ErrNetworkFailure)
+	_, err := client.GetNode()
+	assert.Equal(t, ErrNoAvailableNodes, err)
+
+	time.Sleep(20 * time.Millisecond)
+	node, err := client.GetNode()
+	assert.NoError(t, err)
+	assert.Equal(t, StatusProbation, node.Status)
+	assert.Equal(t, 1, node.Weight)
+}
+
+// TestRemoveNode 测试删除节点
+func TestRemoveNode(t *testing.T) {
+	client, _ := NewClient(1, 10, 5, &WeightedRoundRobinLoadBalancer{}, time.Hour)
+	defer client.Close()
+	client.AddNode("a")
+	client.AddNode("b")
+	client.AddNode("c")
+	client.UpdateNodeStatus("b", ErrTimeout)
+	client.UpdateNodeStatus("c", ErrNetworkFailure)
+
+	for _, url := range []string{"a", "b", "c"} {
+		client.RemoveNode(url)
+		_, ok := client.Node(url)
+		assert.False(t, ok, url)
+	}
+	// 不存在的节点
+	client.RemoveNode("d")
+	_, err := client.GetNode()
+	assert.Equal(t, ErrNoAvailableNodes, err)
 }
 
 // TestClose 测试关闭客户端功能
@@ -229,7 +267,7 @@ func TestConcurrentOperations(t *testing.T) {
 	}
 
 	// 模拟负载均衡器的Select方法
-	lb.On("Select", mock.Anything).Return(client.healthyNodes[0], nil)
+	lb.On("Select", mock.Anything).Return(nodesWithStatus(client, StatusHealthy)[0], nil)
 
 	var wg sync.WaitGroup
 	operations := 1000
@@ -266,6 +304,17 @@ func TestConcurrentOperations(t *testing.T) {
 	wg.Wait()
 
 	// 验证最终状态
-	assert.Greater(t, len(client.healthyNodes), 10)
+	assert.Greater(t, len(nodesWithStatus(client, StatusHealthy)), 10)
 	assert.NotPanics(t, func() { client.Close() })
 }
+
+// nodesWithStatus 状态是 status 的节点的副本，按照加入的顺序排列
+func nodesWithStatus(c *Client, status string) []*Node {
+	var res []*Node
+	for _, n := range c.balancer.List() {
+		if string(n.Status) == status {
+			res = append(res, toNode(n))
+		}
+	}
+	return res
+}
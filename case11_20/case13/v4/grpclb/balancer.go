@@ -0,0 +1,191 @@
+package grpclb
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v4 "interview-cases/case11_20/case13/v4"
+)
+
+// Name 注册到 gRPC 的负载均衡名字，在 service config 里面使用：
+// {"loadBalancingConfig":[{"case13_weighted":{}}]}
+const Name = "case13_weighted"
+
+func init() {
+	balancer.Register(NewBuilder(Name, DefaultConfig))
+}
+
+// Config 和 v4.NewClient 的参数含义一样
+type Config struct {
+	MinWeight        int
+	MaxWeight        int
+	DefaultWeight    int
+	RecoveryInterval time.Duration
+}
+
+var DefaultConfig = Config{
+	MinWeight:        1,
+	MaxWeight:        100,
+	DefaultWeight:    10,
+	RecoveryInterval: time.Second * 10,
+}
+
+type builder struct {
+	name string
+	cfg  Config
+}
+
+// NewBuilder 创建一个按照 v4 规则调整权重的 balancer.Builder，需要自己调用 balancer.Register。
+// 每个 ClientConn 都有自己的 v4.Client，SubConn 的地址就是节点的 URL
+func NewBuilder(name string, cfg Config) balancer.Builder {
+	return &builder{name: name, cfg: cfg}
+}
+
+func (b *builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	client, err := v4.NewClient(b.cfg.MinWeight, b.cfg.MaxWeight, b.cfg.DefaultWeight,
+		&v4.WeightedRoundRobinLoadBalancer{}, b.cfg.RecoveryInterval)
+	if err != nil {
+		// 配置是写死在代码里面的，错了直接 panic
+		panic(err)
+	}
+	pb := &pickerBuilder{client: client, known: make(map[string]struct{})}
+	bal := base.NewBalancerBuilder(b.name, pb, base.Config{}).Build(cc, opts)
+	return &weightedBalancer{Balancer: bal, client: client, pb: pb}
+}
+
+func (b *builder) Name() string {
+	return b.name
+}
+
+// weightedBalancer 关闭的时候顺便停掉 v4.Client 的恢复协程，
+// 地址从 resolver 里面消失的时候从 v4.Client 里面删掉
+type weightedBalancer struct {
+	balancer.Balancer
+	client *v4.Client
+	pb     *pickerBuilder
+}
+
+func (b *weightedBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	addrs := make(map[string]struct{}, len(s.ResolverState.Addresses))
+	for _, addr := range s.ResolverState.Addresses {
+		addrs[addr.Addr] = struct{}{}
+	}
+	// 先删掉，base 的 balancer 在下面重新生成 picker 的时候就不会再出现了
+	b.pb.prune(addrs)
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+func (b *weightedBalancer) Close() {
+	b.Balancer.Close()
+	b.client.Close()
+}
+
+type pickerBuilder struct {
+	client *v4.Client
+	mu     sync.Mutex
+	// known 加入过 client 的地址。SubConn 断开之后不会从 client 里面删掉，
+	// 重连之后还是原来的状态和权重；地址从 resolver 里面消失的时候才删掉
+	known map[string]struct{}
+}
+
+// prune 删掉不在 addrs 里面的地址
+func (b *pickerBuilder) prune(addrs map[string]struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for addr := range b.known {
+		if _, ok := addrs[addr]; !ok {
+			delete(b.known, addr)
+			b.client.RemoveNode(addr)
+		}
+	}
+}
+
+func (b *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	scs := make(map[string]balancer.SubConn, len(info.ReadySCs))
+	ready := make([]string, 0, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		addr := sci.Address.Addr
+		scs[addr] = sc
+		ready = append(ready, addr)
+		if _, ok := b.known[addr]; !ok {
+			b.known[addr] = struct{}{}
+			b.client.AddNode(addr)
+		}
+	}
+	// 没有 READY 的节点不能选
+	var notReady []string
+	for addr := range b.known {
+		if _, ok := scs[addr]; !ok {
+			notReady = append(notReady, addr)
+		}
+	}
+	slices.Sort(ready)
+	return &picker{client: b.client, scs: scs, ready: ready, notReady: notReady}
+}
+
+type picker struct {
+	client   *v4.Client
+	scs      map[string]balancer.SubConn
+	ready    []string
+	notReady []string
+	// next client 里面没有可用节点的时候，在 ready 里面轮流选
+	next atomic.Uint32
+}
+
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if len(p.scs) == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	node, err := p.client.GetNodeExcept(p.notReady...)
+	if err != nil {
+		// 每个节点都偶然返回过 Unavailable 的时候 client 会把节点全部摘掉，但是 SubConn 还是 READY 的。
+		// 不要直接失败，在 READY 的 SubConn 里面轮流选，请求成功之后节点会恢复
+		addr := p.ready[int(p.next.Add(1)-1)%len(p.ready)]
+		return p.result(addr), nil
+	}
+	if _, ok := p.scs[node.URL]; !ok {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	return p.result(node.URL), nil
+}
+
+// result 选中 addr，请求结束之后上报节点状态
+func (p *picker) result(addr string) balancer.PickResult {
+	return balancer.PickResult{
+		SubConn: p.scs[addr],
+		Done: func(info balancer.DoneInfo) {
+			err, ok := classify(info.Err)
+			if ok {
+				p.client.UpdateNodeStatus(addr, err)
+			}
+		},
+	}
+}
+
+// classify 把 RPC 的错误码转成 v4.Client 认识的错误，ok 为 false 表示不用上报
+func classify(err error) (error, bool) {
+	if err == nil {
+		return nil, true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return v4.ErrNetworkFailure, true
+	case codes.DeadlineExceeded:
+		return v4.ErrTimeout, true
+	case codes.ResourceExhausted:
+		return v4.ErrThrottling, true
+	case codes.Canceled:
+		// 调用方自己取消的，和节点无关
+		return nil, false
+	}
+	// 其他错误码是业务错误，说明节点是正常的
+	return nil, true
+}
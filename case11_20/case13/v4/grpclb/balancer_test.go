@@ -0,0 +1,246 @@
+package grpclb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	v4 "interview-cases/case11_20/case13/v4"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+func TestPicker_Done(t *testing.T) {
+	testCases := []struct {
+		name       string
+		err        error
+		wantStatus string
+		wantWeight int
+	}{
+		{name: "成功", err: nil, wantStatus: v4.StatusHealthy, wantWeight: 11},
+		{name: "业务错误", err: status.Error(codes.NotFound, "not found"), wantStatus: v4.StatusHealthy, wantWeight: 11},
+		{name: "节点不可用", err: status.Error(codes.Unavailable, "unavailable"), wantStatus: v4.StatusUnhealthy, wantWeight: 0},
+		{name: "超时", err: status.Error(codes.DeadlineExceeded, "timeout"), wantStatus: v4.StatusProbation, wantWeight: 9},
+		{name: "限流", err: status.Error(codes.ResourceExhausted, "limited"), wantStatus: v4.StatusProbation, wantWeight: 5},
+		{name: "调用方取消", err: status.Error(codes.Canceled, "canceled"), wantStatus: v4.StatusHealthy, wantWeight: 10},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pb, client := newPickerBuilder(t)
+			p := pb.Build(buildInfo("a"))
+			res, err := p.Pick(balancer.PickInfo{})
+			require.NoError(t, err)
+			assert.Equal(t, "a", res.SubConn.(*fakeSubConn).addr)
+			res.Done(balancer.DoneInfo{Err: tc.err})
+
+			node, ok := client.Node("a")
+			require.True(t, ok)
+			assert.Equal(t, tc.wantStatus, node.Status)
+			assert.Equal(t, tc.wantWeight, node.Weight)
+		})
+	}
+}
+
+func TestPicker_Rebuild(t *testing.T) {
+	pb, client := newPickerBuilder(t)
+	p := pb.Build(buildInfo("a", "b"))
+	res, err := p.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	addr := res.SubConn.(*fakeSubConn).addr
+	res.Done(balancer.DoneInfo{Err: status.Error(codes.ResourceExhausted, "limited")})
+
+	// b 断开了，只能选 a
+	p = pb.Build(buildInfo("a"))
+	for i := 0; i < 5; i++ {
+		res, err = p.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		assert.Equal(t, "a", res.SubConn.(*fakeSubConn).addr)
+	}
+
+	// 重连之后还是原来的状态
+	pb.Build(buildInfo("a", "b"))
+	node, ok := client.Node(addr)
+	require.True(t, ok)
+	assert.Equal(t, v4.StatusProbation, node.Status)
+	assert.Equal(t, 5, node.Weight)
+
+	// 没有 READY 的 SubConn
+	_, err = pb.Build(buildInfo()).Pick(balancer.PickInfo{})
+	assert.Equal(t, balancer.ErrNoSubConnAvailable, err)
+}
+
+func TestPicker_NoAvailableNodes(t *testing.T) {
+	pb, client := newPickerBuilder(t)
+	p := pb.Build(buildInfo("a", "b"))
+	for i := 0; i < 2; i++ {
+		res, err := p.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		res.Done(balancer.DoneInfo{Err: status.Error(codes.Unavailable, "unavailable")})
+	}
+	_, err := client.GetNode()
+	require.ErrorIs(t, err, v4.ErrNoAvailableNodes)
+
+	// 节点都被摘掉了，在 READY 的 SubConn 里面轮流选
+	var addrs []string
+	for i := 0; i < 4; i++ {
+		res, err := p.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		addrs = append(addrs, res.SubConn.(*fakeSubConn).addr)
+	}
+	assert.Equal(t, []string{"a", "b", "a", "b"}, addrs)
+
+	// 成功之后节点恢复
+	res, err := p.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	res.Done(balancer.DoneInfo{})
+	node, ok := client.Node(res.SubConn.(*fakeSubConn).addr)
+	require.True(t, ok)
+	assert.Equal(t, v4.StatusHealthy, node.Status)
+}
+
+// stubBalancer 只记录 UpdateClientConnState，其它方法不会被调用
+type stubBalancer struct {
+	balancer.Balancer
+	states []balancer.ClientConnState
+}
+
+func (b *stubBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	b.states = append(b.states, s)
+	return nil
+}
+
+func TestBalancer_Prune(t *testing.T) {
+	pb, client := newPickerBuilder(t)
+	stub := &stubBalancer{}
+	bal := &weightedBalancer{Balancer: stub, client: client, pb: pb}
+	pb.Build(buildInfo("a", "b", "c"))
+
+	// c 从 resolver 里面消失了
+	s := balancer.ClientConnState{ResolverState: resolver.State{
+		Addresses: []resolver.Address{{Addr: "a"}, {Addr: "b"}},
+	}}
+	require.NoError(t, bal.UpdateClientConnState(s))
+	assert.Equal(t, []balancer.ClientConnState{s}, stub.states)
+	assert.Equal(t, map[string]struct{}{"a": {}, "b": {}}, pb.known)
+	_, ok := client.Node("c")
+	assert.False(t, ok)
+	_, ok = client.Node("a")
+	assert.True(t, ok)
+
+	// 重新出现的时候从默认的状态开始
+	pb.Build(buildInfo("a", "b", "c"))
+	node, ok := client.Node("c")
+	require.True(t, ok)
+	assert.Equal(t, v4.StatusHealthy, node.Status)
+}
+
+func newPickerBuilder(t *testing.T) (*pickerBuilder, *v4.Client) {
+	client, err := v4.NewClient(1, 100, 10, &v4.WeightedRoundRobinLoadBalancer{}, time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	return &pickerBuilder{client: client, known: make(map[string]struct{})}, client
+}
+
+func buildInfo(addrs ...string) base.PickerBuildInfo {
+	scs := make(map[balancer.SubConn]base.SubConnInfo, len(addrs))
+	for _, addr := range addrs {
+		scs[&fakeSubConn{addr: addr}] = base.SubConnInfo{Address: resolver.Address{Addr: addr}}
+	}
+	return base.PickerBuildInfo{ReadySCs: scs}
+}
+
+// healthServer 用健康检查服务模拟业务，每次都返回 code
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	code codes.Code
+	cnt  atomic.Int32
+}
+
+func (s *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s.cnt.Add(1)
+	if s.code != codes.OK {
+		return nil, status.Error(s.code, s.code.String())
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func TestBalancer_Bufconn(t *testing.T) {
+	servers := map[string]*healthServer{
+		"ok":        {code: codes.OK},
+		"down":      {code: codes.Unavailable},
+		"throttled": {code: codes.ResourceExhausted},
+	}
+	listeners := make(map[string]*bufconn.Listener, len(servers))
+	var addrs []resolver.Address
+	for addr, hs := range servers {
+		lis := bufconn.Listen(1024 * 1024)
+		listeners[addr] = lis
+		addrs = append(addrs, resolver.Address{Addr: addr})
+		server := grpc.NewServer()
+		healthpb.RegisterHealthServer(server, hs)
+		go func() {
+			_ = server.Serve(lis)
+		}()
+		t.Cleanup(server.Stop)
+	}
+
+	r := manual.NewBuilderWithScheme("case13")
+	r.InitialState(resolver.State{Addresses: addrs})
+	conn, err := grpc.Dial("case13:///svc",
+		grpc.WithInsecure(),
+		grpc.WithResolvers(r),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			lis, ok := listeners[addr]
+			if !ok {
+				return nil, fmt.Errorf("未知地址 %s", addr)
+			}
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, Name)),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	// 等三个 SubConn 都连上，不然前面的请求只会落在先连上的节点
+	require.Eventually(t, func() bool {
+		for _, hs := range servers {
+			if hs.cnt.Load() == 0 {
+				_, _ = client.Check(ctx, &healthpb.HealthCheckRequest{})
+				return false
+			}
+		}
+		return true
+	}, time.Second*3, time.Millisecond*10)
+
+	for _, hs := range servers {
+		hs.cnt.Store(0)
+	}
+	for i := 0; i < 100; i++ {
+		_, _ = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	}
+	// 返回 Unavailable 的节点已经被摘掉了
+	assert.Equal(t, int32(0), servers["down"].cnt.Load())
+	assert.Equal(t, int32(100), servers["ok"].cnt.Load()+servers["throttled"].cnt.Load())
+	// 限流的节点权重降到了最低，流量跟着权重走
+	assert.Greater(t, servers["ok"].cnt.Load(), servers["throttled"].cnt.Load()*5)
+}
@@ -138,12 +138,12 @@ func (s *IntegrationTestSuite) TestDynamicWeightAdjustment() {
 		for i := 0; i < 200; i++ {
 			s.client.UpdateNodeStatus(node.URL, nil) // 持续成功
 		}
-		s.Assert().LessOrEqual(s.client.findNode(node.URL).Weight, s.client.maxWeight)
+		s.Assert().LessOrEqual(s.client.findNode(node.URL).Weight, s.client.strategy.MaxWeight)
 
 		for i := 0; i < 200; i++ {
 			s.client.UpdateNodeStatus(node.URL, ErrThrottling) // 持续限流
 		}
-		s.Assert().GreaterOrEqual(s.client.findNode(node.URL).Weight, s.client.minWeight)
+		s.Assert().GreaterOrEqual(s.client.findNode(node.URL).Weight, s.client.strategy.MinWeight)
 	})
 }
 
@@ -223,7 +223,7 @@ func (s *IntegrationTestSuite) TestNodeRecoveryMechanism() {
 		s.client.UpdateNodeStatus(node.URL, ErrNetworkFailure)
 		time.Sleep(s.client.recoveryInterval + time.Second)
 		s.client.tryRecoverNodes()
-		s.Assert().Equal(s.client.minWeight, s.client.findNode(node.URL).Weight)
+		s.Assert().Equal(s.client.strategy.MinWeight, s.client.findNode(node.URL).Weight)
 	})
 }
 
@@ -276,16 +276,19 @@ func (s *IntegrationTestSuite) TestEdgeCases() {
 
 	s.Run("SingleNodeScenario", func() {
 		// 临时移除其他节点
-		backupNodes := s.client.healthyNodes[1:]
-		s.client.healthyNodes = s.client.healthyNodes[:1]
+		for _, node := range s.nodes[1:] {
+			s.client.RemoveNode(node.URL)
+		}
 		defer func() {
-			s.client.healthyNodes = append(s.client.healthyNodes, backupNodes...)
+			for _, node := range s.nodes[1:] {
+				s.client.AddNode(node.URL)
+			}
 		}()
 
 		for i := 0; i < 10; i++ {
 			node, err := s.client.GetNode()
 			s.Assert().NoError(err)
-			s.Assert().Equal(s.client.healthyNodes[0].URL, node.URL)
+			s.Assert().Equal(s.nodes[0].URL, node.URL)
 		}
 	})
 
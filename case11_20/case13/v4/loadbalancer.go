@@ -1,48 +1,56 @@
 package v4
 
-// WeightedRoundRobinLoadBalancer 实现了加权轮询算法
+import "sync"
+
+// WeightedRoundRobinLoadBalancer 实现了平滑加权轮询算法。
+// 每次选择都读取节点当前的权重，current weight 按照节点 URL 保存，
+// 所以节点权重变化或者换了一批节点都能立刻生效。可以并发使用
 type WeightedRoundRobinLoadBalancer struct {
-	weights        []int
-	currentWeights []int
-	lastIndex      int
+	mu             sync.Mutex
+	currentWeights map[string]int
 }
 
 func (lb *WeightedRoundRobinLoadBalancer) Select(nodes []*Node) (*Node, error) {
-	if len(nodes) == 0 {
-		return nil, ErrNoAvailableNodes
-	}
-
-	// 初始化或重置权重
-	if len(lb.weights) != len(nodes) {
-		lb.weights = make([]int, len(nodes))
-		lb.currentWeights = make([]int, len(nodes))
-		for i, node := range nodes {
-			lb.weights[i] = node.Weight
-		}
-		lb.lastIndex = -1
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if lb.currentWeights == nil {
+		lb.currentWeights = make(map[string]int, len(nodes))
 	}
 
 	totalWeight := 0
-	for i, weight := range lb.weights {
-		totalWeight += weight
-		lb.currentWeights[i] += weight
+	var selected *Node
+	for _, node := range nodes {
+		// 权重小于等于 0 的节点不参与选择
+		if node.Weight <= 0 {
+			delete(lb.currentWeights, node.URL)
+			continue
+		}
+		totalWeight += node.Weight
+		lb.currentWeights[node.URL] += node.Weight
+		if selected == nil || lb.currentWeights[node.URL] > lb.currentWeights[selected.URL] {
+			selected = node
+		}
 	}
-
-	if totalWeight == 0 {
+	if selected == nil {
 		return nil, ErrNoAvailableNodes
 	}
+	lb.currentWeights[selected.URL] -= totalWeight
+	lb.evict(nodes)
+	return selected, nil
+}
 
-	maxWeight := -1000000
-	maxWeightIndex := -1
-	for i, weight := range lb.currentWeights {
-		if weight > maxWeight {
-			maxWeight = weight
-			maxWeightIndex = i
+// evict 删掉已经不在 nodes 里面的节点，节点重新加入的时候从 0 开始
+func (lb *WeightedRoundRobinLoadBalancer) evict(nodes []*Node) {
+	if len(lb.currentWeights) <= len(nodes) {
+		return
+	}
+	urls := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		urls[node.URL] = struct{}{}
+	}
+	for url := range lb.currentWeights {
+		if _, ok := urls[url]; !ok {
+			delete(lb.currentWeights, url)
 		}
 	}
-
-	lb.currentWeights[maxWeightIndex] -= totalWeight
-	lb.lastIndex = maxWeightIndex
-
-	return nodes[maxWeightIndex], nil
 }
@@ -1,6 +1,7 @@
 package v4
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -109,7 +110,7 @@ func TestWeightedRoundRobinLoadBalancerWeightChange(t *testing.T) {
 	nodes[0].Weight = 1
 	nodes[1].Weight = 2
 
-	// 重置负载均衡器以应用新的权重
+	// 新的负载均衡器从头开始轮询
 	lb = &WeightedRoundRobinLoadBalancer{}
 
 	expectedOrder := []string{"node2", "node1", "node2", "node2", "node1", "node2"}
@@ -119,3 +120,71 @@ func TestWeightedRoundRobinLoadBalancerWeightChange(t *testing.T) {
 		assert.Equal(t, expectedOrder[i], node.URL)
 	}
 }
+
+func TestWeightedRoundRobinLoadBalancerLiveWeight(t *testing.T) {
+	nodes := []*Node{
+		{URL: "node1", Weight: 2},
+		{URL: "node2", Weight: 1},
+	}
+	lb := &WeightedRoundRobinLoadBalancer{}
+
+	count := func(n int) map[string]int {
+		res := make(map[string]int)
+		for i := 0; i < n; i++ {
+			node, err := lb.Select(nodes)
+			assert.NoError(t, err)
+			res[node.URL]++
+		}
+		return res
+	}
+	assert.Equal(t, map[string]int{"node1": 20, "node2": 10}, count(30))
+
+	// 不需要重置负载均衡器，权重变化马上生效
+	nodes[0].Weight = 1
+	nodes[1].Weight = 3
+	assert.Equal(t, map[string]int{"node1": 10, "node2": 30}, count(40))
+
+	// 节点数量不变，但是换了一批节点
+	nodes = []*Node{
+		{URL: "node3", Weight: 1},
+		{URL: "node4", Weight: 4},
+	}
+	assert.Equal(t, map[string]int{"node3": 10, "node4": 40}, count(50))
+	// 不在的节点已经删掉了
+	assert.Len(t, lb.currentWeights, 2)
+
+	// 节点的顺序变化不影响结果
+	nodes[0], nodes[1] = nodes[1], nodes[0]
+	assert.Equal(t, map[string]int{"node3": 10, "node4": 40}, count(50))
+}
+
+func TestWeightedRoundRobinLoadBalancerConcurrent(t *testing.T) {
+	nodes := []*Node{
+		{URL: "node1", Weight: 3},
+		{URL: "node2", Weight: 2},
+		{URL: "node3", Weight: 1},
+	}
+	lb := &WeightedRoundRobinLoadBalancer{}
+
+	var (
+		mu     sync.Mutex
+		counts = make(map[string]int)
+		wg     sync.WaitGroup
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 60; j++ {
+				node, err := lb.Select(nodes)
+				assert.NoError(t, err)
+				mu.Lock()
+				counts[node.URL]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// 一共 600 次，每 6 次是一个完整的周期
+	assert.Equal(t, map[string]int{"node1": 300, "node2": 200, "node3": 100}, counts)
+}
@@ -0,0 +1,206 @@
+package v4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ThrottledError 节点返回 429，RetryAfter 是响应头 Retry-After 里面要求等待的时间，没有的话是 0
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%s，%s 之后重试", ErrThrottling.Error(), e.RetryAfter)
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrThrottling
+}
+
+// Transport 实现了 http.RoundTripper，每个请求都通过 Client 挑选一个节点，
+// 然后根据结果自动调用 UpdateNodeStatus。
+// 请求的 URL 只需要 path 和 query，scheme 和 host 会被替换成节点的地址
+type Transport struct {
+	Client *Client
+	// Base 真正发送请求的 RoundTripper，为 nil 的时候使用 http.DefaultTransport
+	Base http.RoundTripper
+	// MaxAttempts 一个请求最多尝试几个不同的节点，小于等于 1 表示不重试。
+	// 只有请求体可以重放的请求才会重试，也就是没有请求体或者设置了 GetBody
+	MaxAttempts int
+	// PerTryTimeout 每次尝试的超时时间，0 表示只用请求自身的 context 控制超时
+	PerTryTimeout time.Duration
+}
+
+// NewTransport 创建一个不重试的 Transport
+func NewTransport(client *Client) *Transport {
+	return &Transport{Client: client}
+}
+
+// RoundTrip 和 http.RoundTripper 要求的一样，无论成功失败都会关闭请求体。
+// 能重放的请求每次尝试都用 GetBody 的副本，原始的请求体在返回的时候关掉；
+// 不能重放的请求只尝试一次，请求体直接交给 Base 关闭
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if hasBody(req) && req.GetBody != nil {
+		defer req.Body.Close()
+	}
+	var tried []string
+	for {
+		node, err := t.Client.GetNodeExcept(tried...)
+		if err != nil {
+			if len(tried) > 0 {
+				// 重试的时候已经没有别的节点了
+				return nil, fmt.Errorf("%w，已经尝试了 %d 个节点", err, len(tried))
+			}
+			closeUnsent(req)
+			return nil, err
+		}
+		tried = append(tried, node.URL)
+
+		resp, failed, err := t.roundTrip(req, node)
+		if !failed || !t.retryable(req, len(tried)) {
+			return resp, err
+		}
+		// 换一个节点之前要把这次的响应体关掉
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+	}
+}
+
+// roundTrip 把请求发到 node 上面，并且上报节点状态。failed 表示节点出了问题，可以换一个节点重试
+func (t *Transport) roundTrip(req *http.Request, node *Node) (*http.Response, bool, error) {
+	target, err := url.Parse(node.URL)
+	if err != nil {
+		closeUnsent(req)
+		return nil, false, fmt.Errorf("节点地址错误 %s: %w", node.URL, err)
+	}
+	ctx := req.Context()
+	cancel := context.CancelFunc(func() {})
+	if t.PerTryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.PerTryTimeout)
+	}
+	outReq := req.Clone(ctx)
+	outReq.URL.Scheme = target.Scheme
+	outReq.URL.Host = target.Host
+	// 让 Host 头跟着节点地址走
+	outReq.Host = ""
+	if hasBody(req) && req.GetBody != nil {
+		outReq.Body, err = req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, false, err
+		}
+	}
+
+	resp, err := t.base().RoundTrip(outReq)
+	if err != nil {
+		cancel()
+		// 调用方自己取消的请求，和节点无关
+		if req.Context().Err() != nil {
+			return nil, false, err
+		}
+		nodeErr := classifyError(err)
+		t.Client.UpdateNodeStatus(node.URL, nodeErr)
+		return nil, true, fmt.Errorf("%w: %w", nodeErr, err)
+	}
+	// 读完响应体之后才能取消 context
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+
+	nodeErr := classifyResponse(resp, time.Now())
+	var te *ThrottledError
+	if errors.As(nodeErr, &te) && te.RetryAfter > 0 {
+		t.Client.Throttle(node.URL, time.Now().Add(te.RetryAfter))
+	}
+	t.Client.UpdateNodeStatus(node.URL, nodeErr)
+	return resp, nodeErr != nil, nil
+}
+
+func (t *Transport) retryable(req *http.Request, attempts int) bool {
+	if attempts >= t.MaxAttempts {
+		return false
+	}
+	if req.Context().Err() != nil {
+		return false
+	}
+	return !hasBody(req) || req.GetBody != nil
+}
+
+func hasBody(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody
+}
+
+// closeUnsent 不能重放的请求体没有交给 Base 的时候要自己关掉
+func closeUnsent(req *http.Request) {
+	if hasBody(req) && req.GetBody == nil {
+		_ = req.Body.Close()
+	}
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// classifyError 把发送请求的错误归类成 Client 认识的错误
+func classifyError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return ErrTimeout
+	}
+	return ErrNetworkFailure
+}
+
+// classifyResponse 根据状态码归类，其余的状态码都认为节点是正常的
+func classifyResponse(resp *http.Response, now time.Time) error {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return &ThrottledError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), now)}
+	case http.StatusServiceUnavailable:
+		return ErrCircuitBreaker
+	case http.StatusGatewayTimeout:
+		return ErrTimeout
+	}
+	return nil
+}
+
+// parseRetryAfter Retry-After 可以是秒数，也可以是一个 HTTP 时间
+func parseRetryAfter(val string, now time.Time) time.Duration {
+	if val == "" {
+		return 0
+	}
+	var d time.Duration
+	if seconds, err := strconv.Atoi(val); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(val); err == nil {
+		d = at.Sub(now)
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
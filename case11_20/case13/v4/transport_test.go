@@ -0,0 +1,288 @@
+package v4
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport_RoundTrip(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handler http.HandlerFunc
+		// closed 节点已经下线，模拟网络故障
+		closed        bool
+		perTryTimeout time.Duration
+		wantCode      int
+		wantErr       error
+		wantStatus    string
+		wantWeight    int
+	}{
+		{
+			name: "正常请求",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: StatusHealthy,
+			wantWeight: 11,
+		},
+		{
+			name: "限流",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			wantCode:   http.StatusTooManyRequests,
+			wantStatus: StatusProbation,
+			wantWeight: 5,
+		},
+		{
+			name: "熔断",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: StatusUnhealthy,
+			wantWeight: 0,
+		},
+		{
+			name: "超时",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(time.Second):
+				case <-r.Context().Done():
+				}
+			},
+			perTryTimeout: time.Millisecond * 50,
+			wantErr:       ErrTimeout,
+			wantStatus:    StatusProbation,
+			wantWeight:    9,
+		},
+		{
+			name:       "网络故障",
+			handler:    func(w http.ResponseWriter, r *http.Request) {},
+			closed:     true,
+			wantErr:    ErrNetworkFailure,
+			wantStatus: StatusUnhealthy,
+			wantWeight: 0,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(tc.handler)
+			defer server.Close()
+			if tc.closed {
+				server.Close()
+			}
+			c, err := NewClient(1, 100, 10, &WeightedRoundRobinLoadBalancer{}, time.Minute)
+			require.NoError(t, err)
+			defer c.Close()
+			c.AddNode(server.URL)
+
+			client := &http.Client{Transport: &Transport{Client: c, PerTryTimeout: tc.perTryTimeout}}
+			resp, err := client.Get("http://svc/hello")
+			assert.ErrorIs(t, err, tc.wantErr)
+			if err == nil {
+				_ = resp.Body.Close()
+				assert.Equal(t, tc.wantCode, resp.StatusCode)
+			}
+			node := c.findNode(server.URL)
+			assert.Equal(t, tc.wantStatus, node.Status)
+			assert.Equal(t, tc.wantWeight, node.Weight)
+		})
+	}
+}
+
+func TestTransport_RewriteURL(t *testing.T) {
+	var got atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.Host + r.URL.RequestURI())
+	}))
+	defer server.Close()
+	c, err := NewClient(1, 100, 10, &WeightedRoundRobinLoadBalancer{}, time.Minute)
+	require.NoError(t, err)
+	defer c.Close()
+	c.AddNode(server.URL)
+
+	client := &http.Client{Transport: NewTransport(c)}
+	resp, err := client.Get("http://svc/users?id=1")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, strings.TrimPrefix(server.URL, "http://")+"/users?id=1", got.Load())
+}
+
+func TestTransport_RetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	c, err := NewClient(1, 100, 10, &WeightedRoundRobinLoadBalancer{}, time.Minute)
+	require.NoError(t, err)
+	defer c.Close()
+	c.AddNode(server.URL)
+
+	client := &http.Client{Transport: NewTransport(c)}
+	resp, err := client.Get("http://svc/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// Retry-After 之前不会再选中这个节点
+	_, err = c.GetNode()
+	assert.Equal(t, ErrNoAvailableNodes, err)
+	_, err = client.Get("http://svc/")
+	assert.ErrorIs(t, err, ErrNoAvailableNodes)
+}
+
+func TestTransport_Retry(t *testing.T) {
+	var badCnt atomic.Int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		badCnt.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer good.Close()
+
+	newClient := func(t *testing.T) *Client {
+		c, err := NewClient(1, 100, 10, &WeightedRoundRobinLoadBalancer{}, time.Minute)
+		require.NoError(t, err)
+		t.Cleanup(c.Close)
+		// 先加的节点先被选中
+		c.AddNode(bad.URL)
+		c.AddNode(good.URL)
+		return c
+	}
+
+	t.Run("换一个节点重试", func(t *testing.T) {
+		badCnt.Store(0)
+		client := &http.Client{Transport: &Transport{Client: newClient(t), MaxAttempts: 2}}
+		for i := 0; i < 10; i++ {
+			resp, err := client.Post("http://svc/", "text/plain", strings.NewReader("hello"))
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+		// 第一次失败之后节点就不可用了
+		assert.Equal(t, int32(1), badCnt.Load())
+	})
+
+	t.Run("请求体不能重放", func(t *testing.T) {
+		badCnt.Store(0)
+		tr := &Transport{Client: newClient(t), MaxAttempts: 2}
+		req, err := http.NewRequest(http.MethodPost, "http://svc/", strings.NewReader("hello"))
+		require.NoError(t, err)
+		req.GetBody = nil
+		resp, err := tr.RoundTrip(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, int32(1), badCnt.Load())
+	})
+
+	t.Run("没有别的节点", func(t *testing.T) {
+		c, err := NewClient(1, 100, 10, &WeightedRoundRobinLoadBalancer{}, time.Minute)
+		require.NoError(t, err)
+		defer c.Close()
+		c.AddNode(bad.URL)
+		client := &http.Client{Transport: &Transport{Client: c, MaxAttempts: 3}}
+		_, err = client.Get("http://svc/")
+		assert.ErrorIs(t, err, ErrNoAvailableNodes)
+	})
+}
+
+// trackedBody 记录 Close 的次数
+type trackedBody struct {
+	*strings.Reader
+	closed *atomic.Int32
+}
+
+func (b *trackedBody) Close() error {
+	b.closed.Add(1)
+	return nil
+}
+
+func TestTransport_CloseBody(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer good.Close()
+
+	newRequest := func(t *testing.T, replay bool) (*http.Request, *atomic.Int32, *atomic.Int32) {
+		var closed, copies atomic.Int32
+		req, err := http.NewRequest(http.MethodPost, "http://svc/",
+			&trackedBody{Reader: strings.NewReader("hello"), closed: &closed})
+		require.NoError(t, err)
+		if replay {
+			req.GetBody = func() (io.ReadCloser, error) {
+				return &trackedBody{Reader: strings.NewReader("hello"), closed: &copies}, nil
+			}
+		}
+		return req, &closed, &copies
+	}
+
+	t.Run("重试的时候只用副本", func(t *testing.T) {
+		c, err := NewClient(1, 100, 10, &WeightedRoundRobinLoadBalancer{}, time.Minute)
+		require.NoError(t, err)
+		defer c.Close()
+		c.AddNode(bad.URL)
+		c.AddNode(good.URL)
+		req, closed, copies := newRequest(t, true)
+		resp, err := (&Transport{Client: c, MaxAttempts: 2}).RoundTrip(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		// 原始的请求体关闭一次，两次尝试的副本都由 Base 关闭
+		assert.Equal(t, int32(1), closed.Load())
+		assert.Eventually(t, func() bool {
+			return copies.Load() == 2
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("没有可用的节点", func(t *testing.T) {
+		c, err := NewClient(1, 100, 10, &WeightedRoundRobinLoadBalancer{}, time.Minute)
+		require.NoError(t, err)
+		defer c.Close()
+		for _, replay := range []bool{true, false} {
+			req, closed, _ := newRequest(t, replay)
+			_, err = (&Transport{Client: c}).RoundTrip(req)
+			assert.ErrorIs(t, err, ErrNoAvailableNodes)
+			assert.Equal(t, int32(1), closed.Load())
+		}
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name string
+		val  string
+		want time.Duration
+	}{
+		{name: "没有", val: "", want: 0},
+		{name: "秒数", val: "3", want: time.Second * 3},
+		{name: "负数", val: "-3", want: 0},
+		{name: "HTTP 时间", val: now.Add(time.Minute).Format(http.TimeFormat), want: time.Minute},
+		{name: "已经过去的时间", val: now.Add(-time.Minute).Format(http.TimeFormat), want: 0},
+		{name: "格式错误", val: "abc", want: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, parseRetryAfter(tc.val, now))
+		})
+	}
+}
@@ -0,0 +1,18 @@
+// Package redisx 各个案例共用的 redis 小工具
+package redisx
+
+import "strings"
+
+// EscapeGlob 转义 SCAN 的 MATCH 里面有特殊含义的字符。
+// 拼进 pattern 的前缀来自用户输入的时候，不转义的话 * 之类的字符会匹配到别人的 key
+func EscapeGlob(s string) string {
+	var sb strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,26 @@
+package redisx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapeGlob(t *testing.T) {
+	testCases := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{name: "普通字符", s: "article:3:tom:", want: "article:3:tom:"},
+		{name: "通配符", s: "a*?b", want: `a\*\?b`},
+		{name: "字符集", s: "[ab]", want: `\[ab\]`},
+		{name: "反斜杠", s: `a\b`, want: `a\\b`},
+		{name: "全部", s: `node*?[1]\:`, want: `node\*\?\[1\]\\:`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, EscapeGlob(tc.s))
+		})
+	}
+}